@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+// addonStartTimeout covers pulling the image on first use.
+const addonStartTimeout = 10 * time.Minute
+
+var envVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// addonTemplate describes how to run one kind of add-on.
+type addonTemplate struct {
+	image          string
+	defaultVersion string
+	dataPath       string
+	envVar         string
+	// cmd overrides the image's command; nil keeps it.
+	cmd func(a models.Addon) []string
+	env func(a models.Addon) []string
+	url func(a models.Addon) string
+	// dump writes a backup of the add-on's data to stdout when run inside
+	// its container. dumpFile is what it is called in backup archives.
+	dump     []string
+	dumpFile string
+}
+
+var addonTemplates = map[models.AddonType]addonTemplate{
+	models.AddonPostgres: {
+		image:          "postgres",
+		defaultVersion: "16-alpine",
+		dataPath:       "/var/lib/postgresql/data",
+		envVar:         "DATABASE_URL",
+		env: func(a models.Addon) []string {
+			return []string{"POSTGRES_USER=" + a.Username, "POSTGRES_PASSWORD=" + a.Password, "POSTGRES_DB=" + a.Database}
+		},
+		url: func(a models.Addon) string {
+			return fmt.Sprintf("postgres://%s:%s@%s:5432/%s", a.Username, a.Password, a.Name, a.Database)
+		},
+		dump:     []string{"sh", "-c", `pg_dump -U "$POSTGRES_USER" -d "$POSTGRES_DB" --clean --if-exists`},
+		dumpFile: "dump.sql",
+	},
+	models.AddonMySQL: {
+		image:          "mysql",
+		defaultVersion: "8.4",
+		dataPath:       "/var/lib/mysql",
+		envVar:         "DATABASE_URL",
+		env: func(a models.Addon) []string {
+			return []string{"MYSQL_ROOT_PASSWORD=" + a.Password, "MYSQL_DATABASE=" + a.Database, "MYSQL_USER=" + a.Username, "MYSQL_PASSWORD=" + a.Password}
+		},
+		url: func(a models.Addon) string {
+			return fmt.Sprintf("mysql://%s:%s@%s:3306/%s", a.Username, a.Password, a.Name, a.Database)
+		},
+		dump:     []string{"sh", "-c", `MYSQL_PWD="$MYSQL_ROOT_PASSWORD" mysqldump -uroot --single-transaction --routines --databases "$MYSQL_DATABASE"`},
+		dumpFile: "dump.sql",
+	},
+	models.AddonRedis: {
+		image:          "redis",
+		defaultVersion: "7-alpine",
+		dataPath:       "/data",
+		envVar:         "REDIS_URL",
+		// The password stays out of the container's command line.
+		cmd: func(a models.Addon) []string {
+			return []string{"sh", "-c", `exec redis-server --appendonly yes --requirepass "$REDIS_PASSWORD"`}
+		},
+		env: func(a models.Addon) []string {
+			return []string{"REDIS_PASSWORD=" + a.Password}
+		},
+		url: func(a models.Addon) string {
+			return fmt.Sprintf("redis://:%s@%s:6379", a.Password, a.Name)
+		},
+		dump:     []string{"sh", "-c", `redis-cli -a "$REDIS_PASSWORD" --no-auth-warning --rdb /tmp/orchestro-dump.rdb >/dev/null && cat /tmp/orchestro-dump.rdb && rm -f /tmp/orchestro-dump.rdb`},
+		dumpFile: "dump.rdb",
+	},
+}
+
+// addonNetwork is the Orchestro network a project shares with its add-ons.
+func addonNetwork(projectID uint) string {
+	return fmt.Sprintf("addons-p%d", projectID)
+}
+
+func addonContainerName(a models.Addon) string {
+	return fmt.Sprintf("orchestro-addon-p%d-%s", a.ProjectID, a.Name)
+}
+
+func addonVolumeName(a models.Addon) string {
+	return fmt.Sprintf("orchestro-addon-p%d-%s", a.ProjectID, a.Name)
+}
+
+func generatePassword() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// addonEnv is what the project's containers get for their add-ons.
+func addonEnv(addons []models.Addon) []string {
+	var env []string
+	for _, a := range addons {
+		if tmpl, ok := addonTemplates[a.Type]; ok {
+			env = append(env, a.EnvVar+"="+tmpl.url(a))
+		}
+	}
+	return env
+}
+
+// startAddon (re)creates the add-on's container. Its data lives in a named
+// volume, so recreating it keeps the data.
+func startAddon(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, addon *models.Addon) error {
+	tmpl := addonTemplates[addon.Type]
+	network := dockerNetworkName(addonNetwork(addon.ProjectID))
+	labels := map[string]string{
+		"orchestro.project": fmt.Sprintf("%d", addon.ProjectID),
+		"orchestro.addon":   addon.Name,
+	}
+
+	if err := orch.EnsureNetwork(ctx, network, map[string]string{"orchestro.network": addonNetwork(addon.ProjectID)}); err != nil {
+		return fmt.Errorf("failed to create network: %v", err)
+	}
+	if err := orch.CreateVolume(ctx, addonVolumeName(*addon), labels); err != nil {
+		return fmt.Errorf("failed to create volume: %v", err)
+	}
+	if addon.ContainerID != "" {
+		orch.RemoveContainer(context.Background(), addon.ContainerID)
+	}
+
+	var cmd []string
+	if tmpl.cmd != nil {
+		cmd = tmpl.cmd(*addon)
+	}
+	containerID, err := orch.RunService(ctx, tmpl.image+":"+addon.Version, addonContainerName(*addon), cmd, tmpl.env(*addon),
+		[]string{addonVolumeName(*addon) + ":" + tmpl.dataPath},
+		orchestrator.Networks{network: {addon.Name}}, labels)
+	addon.ContainerID = containerID
+	addon.Status = "running"
+	if err != nil {
+		addon.Status = "failed"
+	}
+	db.Save(addon)
+	return err
+}
+
+// ensureAddons brings back add-on containers that stopped or were removed,
+// before a deploy relies on them.
+func ensureAddons(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, project models.Project) error {
+	for i := range project.Addons {
+		addon := &project.Addons[i]
+		if addon.ContainerID != "" {
+			status, err := orch.GetContainerStatus(ctx, addon.ContainerID)
+			if err == nil && status == "running" {
+				continue
+			}
+			if err == nil && orch.StartContainer(ctx, addon.ContainerID) == nil {
+				continue
+			}
+		}
+		if err := startAddon(ctx, db, orch, addon); err != nil {
+			return fmt.Errorf("add-on %s: %v", addon.Name, err)
+		}
+	}
+	return nil
+}
+
+// removeAddons deletes the add-ons' containers and data.
+func removeAddons(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, projectID uint, addons []models.Addon) {
+	for _, a := range addons {
+		if a.ContainerID != "" {
+			orch.StopContainer(ctx, a.ContainerID)
+			orch.RemoveContainer(ctx, a.ContainerID)
+		}
+		if err := orch.RemoveVolume(ctx, addonVolumeName(a)); err != nil {
+			fmt.Printf("Failed to remove volume of add-on %s: %v\n", a.Name, err)
+		}
+		db.Delete(&a)
+	}
+	var remaining int64
+	db.Model(&models.Addon{}).Where("project_id = ?", projectID).Count(&remaining)
+	if remaining == 0 {
+		// Fails while the project's containers are still attached; the next
+		// deploy no longer joins it.
+		orch.RemoveNetwork(ctx, dockerNetworkName(addonNetwork(projectID)))
+	}
+}
+
+// dumpAddon writes a backup of the add-on's data to w with its template's
+// dump tool.
+func dumpAddon(ctx context.Context, orch *orchestrator.DockerOrchestrator, addon models.Addon, w io.Writer) error {
+	tmpl, ok := addonTemplates[addon.Type]
+	if !ok {
+		return fmt.Errorf("unknown add-on type %q", addon.Type)
+	}
+	if addon.ContainerID == "" {
+		return fmt.Errorf("add-on %s is not running", addon.Name)
+	}
+	if _, err := orch.ExecToWriter(ctx, addon.ContainerID, tmpl.dump, w); err != nil {
+		return fmt.Errorf("failed to dump add-on %s: %v", addon.Name, err)
+	}
+	return nil
+}
+
+// showAddons fills in connection URLs for callers allowed to see secrets and
+// strips passwords for everyone else, like env var values.
+func showAddons(c *gin.Context, addons []models.Addon) {
+	canSee := currentUser(c).Role.Allows(models.RoleDeployer)
+	for i := range addons {
+		if canSee {
+			if tmpl, ok := addonTemplates[addons[i].Type]; ok {
+				addons[i].URL = tmpl.url(addons[i])
+			}
+		} else {
+			addons[i].Password = ""
+		}
+	}
+}
+
+func registerAddonRoutes(db *gorm.DB, orch *orchestrator.DockerOrchestrator, v1 *gin.RouterGroup, projectAccess gin.HandlerFunc) {
+	deployer := requireRole(models.RoleDeployer)
+	readScope := requireScope(models.ScopeDeployRead)
+	deployScope := requireScope(models.ScopeDeployWrite)
+
+	v1.GET("/projects/:id/addons", readScope, projectAccess, func(c *gin.Context) {
+		var addons []models.Addon
+		db.Where("project_id = ?", c.Param("id")).Order("id").Find(&addons)
+		showAddons(c, addons)
+		c.JSON(200, addons)
+	})
+
+	// Creates the add-on and starts it right away. The project picks up the
+	// connection env var with its next deploy.
+	v1.POST("/projects/:id/addons", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		var req struct {
+			Type    models.AddonType `json:"type" binding:"required"`
+			Name    string           `json:"name"`
+			Version string           `json:"version"`
+			EnvVar  string           `json:"env_var"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		tmpl, ok := addonTemplates[req.Type]
+		if !ok {
+			c.JSON(400, gin.H{"error": "type must be one of postgres, mysql, redis"})
+			return
+		}
+		var project models.Project
+		if err := db.First(&project, c.Param("id")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+
+		addon := models.Addon{
+			ProjectID: project.ID,
+			Type:      req.Type,
+			Name:      req.Name,
+			Version:   req.Version,
+			EnvVar:    req.EnvVar,
+			Password:  generatePassword(),
+			Status:    "starting",
+		}
+		if addon.Name == "" {
+			addon.Name = string(req.Type)
+		}
+		if addon.Version == "" {
+			addon.Version = tmpl.defaultVersion
+		}
+		if addon.EnvVar == "" {
+			addon.EnvVar = tmpl.envVar
+		}
+		if req.Type != models.AddonRedis {
+			addon.Username = "app"
+			addon.Database = "app"
+		}
+		if !networkAliasPattern.MatchString(addon.Name) {
+			c.JSON(400, gin.H{"error": "name must be a DNS label: lowercase letters, digits and '-'"})
+			return
+		}
+		if !envVarNamePattern.MatchString(addon.EnvVar) {
+			c.JSON(400, gin.H{"error": "env_var must be a valid environment variable name"})
+			return
+		}
+		var count int64
+		db.Model(&models.Addon{}).Where("project_id = ? AND (name = ? OR env_var = ?)", project.ID, addon.Name, addon.EnvVar).Count(&count)
+		if count > 0 {
+			c.JSON(409, gin.H{"error": "The project already has an add-on with this name or env var"})
+			return
+		}
+
+		if err := db.Create(&addon).Error; err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), addonStartTimeout)
+		defer cancel()
+		if err := startAddon(ctx, db, orch, &addon); err != nil {
+			removeAddons(context.Background(), db, orch, project.ID, []models.Addon{addon})
+			c.JSON(500, gin.H{"error": "Failed to start add-on: " + err.Error()})
+			return
+		}
+
+		auditAfter(c, addon)
+		created := []models.Addon{addon}
+		showAddons(c, created)
+		c.JSON(201, created[0])
+	})
+
+	// Removes the add-on together with its data.
+	v1.DELETE("/projects/:id/addons/:addonId", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		var addon models.Addon
+		if err := db.Where("project_id = ?", c.Param("id")).First(&addon, c.Param("addonId")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Add-on not found"})
+			return
+		}
+		auditBefore(c, addon)
+		removeAddons(c.Request.Context(), db, orch, addon.ProjectID, []models.Addon{addon})
+		c.Status(204)
+	})
+}
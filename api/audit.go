@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timuzkas/orchestro/api/models"
+	"gorm.io/gorm"
+)
+
+// auditActions names the mutating routes. Routes missing here are still
+// audited, with "METHOD /path" as their action.
+var auditActions = map[string]string{
+	"POST /api/v1/auth/login":                       "auth.login",
+	"POST /api/v1/auth/logout":                      "auth.logout",
+	"POST /api/v1/webhooks/:id/:provider":           "webhook.receive",
+	"POST /api/v1/projects":                         "project.create",
+	"PUT /api/v1/projects/:id":                      "project.update",
+	"DELETE /api/v1/projects/:id":                   "project.delete",
+	"POST /api/v1/projects/:id/env":                 "env.create",
+	"DELETE /api/v1/projects/:id/env/:envId":        "env.delete",
+	"POST /api/v1/projects/:id/volumes":             "volume.create",
+	"DELETE /api/v1/projects/:id/volumes/:volumeId": "volume.delete",
+	"PUT /api/v1/projects/:id/files":                "file.upload",
+	"DELETE /api/v1/projects/:id/files":             "file.delete",
+	"POST /api/v1/projects/:id/backups":             "backup.create",
+	"POST /api/v1/backups":                          "backup.create_instance",
+	"DELETE /api/v1/backups/:backupId":              "backup.delete",
+	"DELETE /api/v1/backups":                        "backup.delete_bulk",
+	"POST /api/v1/backups/:backupId/verify":         "backup.verify",
+	"POST /api/v1/projects/:id/run":                 "project.run",
+	"POST /api/v1/projects/:id/cronjobs":            "cronjob.create",
+	"PUT /api/v1/projects/:id/cronjobs/:jobId":      "cronjob.update",
+	"DELETE /api/v1/projects/:id/cronjobs/:jobId":   "cronjob.delete",
+	"POST /api/v1/projects/:id/cronjobs/:jobId/run": "cronjob.run",
+	"POST /api/v1/projects/:id/addons":              "addon.create",
+	"DELETE /api/v1/projects/:id/addons/:addonId":   "addon.delete",
+	"POST /api/v1/projects/:id/deploy":              "deploy.start",
+	"POST /api/v1/projects/:id/deploy/cancel":       "deploy.cancel",
+	"POST /api/v1/projects/:id/deploy/upload":       "deploy.upload",
+	"DELETE /api/v1/projects/:id/build-cache":       "build_cache.clear",
+	"POST /api/v1/images/gc":                        "images.gc",
+	"POST /api/v1/projects/:id/clear-data":          "project.clear_data",
+	"POST /api/v1/projects/:id/pause":               "project.pause",
+	"POST /api/v1/projects/:id/resume":              "project.resume",
+	"POST /api/v1/users":                            "user.create",
+	"PUT /api/v1/users/:userId":                     "user.update",
+	"PUT /api/v1/users/:userId/projects":            "user.set_projects",
+	"DELETE /api/v1/users/:userId":                  "user.delete",
+	"POST /api/v1/tokens":                           "token.create",
+	"DELETE /api/v1/tokens/:tokenId":                "token.delete",
+}
+
+// sensitiveFields never reach the audit log in clear text. Env var values are
+// all treated as secrets.
+var sensitiveFields = map[string]bool{
+	"value":             true,
+	"webhook_secret":    true,
+	"password":          true,
+	"token":             true,
+	"registry_password": true,
+}
+
+// sensitiveMaps have their values redacted but keep their keys, so the log
+// still shows which build args were added or removed.
+var sensitiveMaps = map[string]bool{
+	"build_args": true,
+}
+
+// nestedFields are left out of snapshots; changes to them are audited by
+// their own routes.
+var nestedFields = []string{"env_vars", "deployments", "backups", "volumes", "addons", "projects"}
+
+const redacted = "[redacted]"
+
+func isMutating(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH" || method == "DELETE"
+}
+
+// auditMiddleware records every mutating request once its handler has run.
+// Handlers can enrich the event with auditBefore/auditAfter snapshots.
+func auditMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		c.Next()
+
+		route := c.Request.Method + " " + c.FullPath()
+		action, ok := auditActions[route]
+		if !ok {
+			action = route
+		}
+
+		event := newAuditEvent(c, action)
+		event.Status = c.Writer.Status()
+
+		before, _ := c.Get("audit_before")
+		after, _ := c.Get("audit_after")
+		if changes := auditDiff(before, after); len(changes) > 0 {
+			event.Changes = changes
+		}
+
+		if err := db.Create(&event).Error; err != nil {
+			fmt.Printf("Failed to write audit event %s: %v\n", action, err)
+		}
+	}
+}
+
+// newAuditEvent fills in who made the request and which project it is about.
+func newAuditEvent(c *gin.Context, action string) models.AuditEvent {
+	event := models.AuditEvent{
+		Action: action,
+		Method: c.Request.Method,
+		Path:   c.Request.URL.Path,
+		IP:     c.ClientIP(),
+	}
+
+	if user := currentUser(c); user != nil {
+		event.Actor = user.Username
+		if user.ID != 0 {
+			event.ActorID = &user.ID
+		}
+	} else if actor := c.GetString("audit_actor"); actor != "" {
+		event.Actor = actor
+	}
+	if t := currentAPIToken(c); t != nil {
+		event.TokenID = &t.ID
+	}
+
+	if pid, ok := c.Get("audit_project_id"); ok {
+		id := pid.(uint)
+		event.ProjectID = &id
+	} else if id, err := strconv.ParseUint(c.Param("id"), 10, 64); err == nil {
+		pid := uint(id)
+		event.ProjectID = &pid
+	}
+	return event
+}
+
+// recordAudit writes an event for things that are not a single mutating
+// request, like a terminal session.
+func recordAudit(db *gorm.DB, c *gin.Context, action string, details map[string]interface{}) {
+	event := newAuditEvent(c, action)
+	event.Changes = details
+	if err := db.Create(&event).Error; err != nil {
+		fmt.Printf("Failed to write audit event %s: %v\n", action, err)
+	}
+}
+
+func auditBefore(c *gin.Context, v interface{}) {
+	c.Set("audit_before", snapshot(v))
+}
+
+func auditAfter(c *gin.Context, v interface{}) {
+	c.Set("audit_after", snapshot(v))
+}
+
+// snapshot turns v into a JSON object. It is taken eagerly because handlers
+// keep mutating their structs afterwards. Snapshots stay in the request
+// context; secrets are only redacted once they are diffed.
+func snapshot(v interface{}) map[string]interface{} {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	for _, k := range nestedFields {
+		delete(m, k)
+	}
+	return m
+}
+
+// redact replaces the value of a sensitive field, keeping empty strings so
+// that setting or clearing a secret still shows up in the log.
+func redact(key string, v interface{}) interface{} {
+	if m, ok := v.(map[string]interface{}); ok && sensitiveMaps[key] {
+		out := make(map[string]interface{}, len(m))
+		for k, value := range m {
+			out[k] = redact("value", value)
+		}
+		return out
+	}
+	if !sensitiveFields[key] || v == nil || v == "" {
+		return v
+	}
+	return redacted
+}
+
+// auditDiff lists the top-level fields that differ between two snapshots. A
+// creation only has "to" values and a deletion only "from" values.
+func auditDiff(before, after interface{}) map[string]interface{} {
+	b, _ := before.(map[string]interface{})
+	a, _ := after.(map[string]interface{})
+	if b == nil && a == nil {
+		return nil
+	}
+
+	changes := make(map[string]interface{})
+	keys := make(map[string]bool)
+	for k := range b {
+		keys[k] = true
+	}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range keys {
+		if k == "created_at" || k == "updated_at" {
+			continue
+		}
+		from, inBefore := b[k]
+		to, inAfter := a[k]
+		if inBefore && inAfter && reflect.DeepEqual(from, to) {
+			continue
+		}
+		change := map[string]interface{}{}
+		if b != nil {
+			change["from"] = redact(k, from)
+		}
+		if a != nil {
+			change["to"] = redact(k, to)
+		}
+		changes[k] = change
+	}
+	return changes
+}
+
+func registerAuditRoutes(db *gorm.DB, v1 *gin.RouterGroup) {
+	v1.GET("/audit", requireRole(models.RoleAdmin), requireScope(models.ScopeAdmin), func(c *gin.Context) {
+		query := db.Model(&models.AuditEvent{})
+		if actor := c.Query("actor"); actor != "" {
+			query = query.Where("actor = ?", actor)
+		}
+		if action := c.Query("action"); action != "" {
+			if strings.HasSuffix(action, ".*") {
+				query = query.Where("action LIKE ?", strings.TrimSuffix(action, "*")+"%")
+			} else {
+				query = query.Where("action = ?", action)
+			}
+		}
+		if projectID := c.Query("project_id"); projectID != "" {
+			query = query.Where("project_id = ?", projectID)
+		}
+		for param, op := range map[string]string{"since": ">=", "until": "<"} {
+			if v := c.Query(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					c.JSON(400, gin.H{"error": param + " must be an RFC 3339 timestamp"})
+					return
+				}
+				query = query.Where("created_at "+op+" ?", t)
+			}
+		}
+		if beforeID := c.Query("before_id"); beforeID != "" {
+			query = query.Where("id < ?", beforeID)
+		}
+
+		jsonl := c.Query("format") == "jsonl"
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 && !jsonl {
+			limit = 100
+		}
+		if limit > 0 {
+			query = query.Limit(limit)
+		}
+
+		if !jsonl {
+			var events []models.AuditEvent
+			query.Order("id DESC").Find(&events)
+			c.JSON(200, events)
+			return
+		}
+
+		// JSON lines export, oldest first, streamed in batches.
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="orchestro-audit.jsonl"`)
+		c.Status(200)
+		enc := json.NewEncoder(c.Writer)
+		var batch []models.AuditEvent
+		query.Order("id ASC").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+			for _, event := range batch {
+				if err := enc.Encode(event); err != nil {
+					return err
+				}
+			}
+			c.Writer.Flush()
+			return nil
+		})
+	})
+}
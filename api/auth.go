@@ -0,0 +1,507 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timuzkas/orchestro/api/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	sessionTTL         = 7 * 24 * time.Hour
+	sessionTokenPrefix = "os_"
+)
+
+// bootstrapAdmin turns the legacy API_USER/API_PASS pair into the first admin
+// account so existing installs keep working after upgrading.
+func bootstrapAdmin(db *gorm.DB) {
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	apiUser := os.Getenv("API_USER")
+	apiPass := os.Getenv("API_PASS")
+	if apiUser == "" || apiPass == "" {
+		fmt.Println("WARNING: no users exist and API_USER/API_PASS are not set, the API is unauthenticated")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(apiPass), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Printf("Failed to hash bootstrap admin password: %v\n", err)
+		return
+	}
+	db.Create(&models.User{Username: apiUser, PasswordHash: string(hash), Role: models.RoleAdmin})
+	fmt.Printf("Created admin user %q from API_USER\n", apiUser)
+}
+
+func newToken(prefix string) (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := prefix + hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func checkPassword(db *gorm.DB, username, password string) (*models.User, bool) {
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, false
+	}
+	return &user, true
+}
+
+// requestToken extracts a bearer token from the Authorization header, or from
+// the token query parameter for WebSocket upgrades and download links, where
+// browsers cannot set headers.
+func requestToken(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if c.IsWebsocket() || strings.HasSuffix(c.FullPath(), "/download") {
+		return c.Query("token")
+	}
+	return ""
+}
+
+// loggedSecrets are the query parameters that carry credentials.
+var loggedSecrets = []string{"token", "secret"}
+
+// requestLogFormatter is gin's request log line with the token and webhook
+// secret query parameters blanked out, so they never end up in the server log.
+func requestLogFormatter(param gin.LogFormatterParams) string {
+	path := param.Path
+	if p, rawQuery, ok := strings.Cut(path, "?"); ok {
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			path = p + "?" + redacted
+		} else {
+			changed := false
+			for _, name := range loggedSecrets {
+				if query.Has(name) {
+					query.Set(name, redacted)
+					changed = true
+				}
+			}
+			if changed {
+				path = p + "?" + query.Encode()
+			}
+		}
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		path,
+		param.ErrorMessage,
+	)
+}
+
+func userForSession(db *gorm.DB, token string) (*models.User, bool) {
+	var session models.Session
+	if err := db.Where("token_hash = ?", hashToken(token)).First(&session).Error; err != nil {
+		return nil, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		db.Delete(&session)
+		return nil, false
+	}
+	var user models.User
+	if err := db.First(&user, session.UserID).Error; err != nil {
+		return nil, false
+	}
+	return &user, true
+}
+
+// authMiddleware accepts a session token, an API token or HTTP basic
+// credentials of any user. When no user exists at all the API stays open, as
+// it did before accounts existed, and every request acts as an admin.
+func authMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var count int64
+		db.Model(&models.User{}).Count(&count)
+		if count == 0 {
+			c.Set("user", &models.User{Username: "anonymous", Role: models.RoleAdmin})
+			c.Next()
+			return
+		}
+
+		token := requestToken(c)
+		if strings.HasPrefix(token, sessionTokenPrefix) {
+			if user, ok := userForSession(db, token); ok {
+				c.Set("user", user)
+				c.Next()
+				return
+			}
+		}
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			if user, apiToken, ok := userForAPIToken(db, token); ok {
+				c.Set("user", user)
+				c.Set("api_token", apiToken)
+				c.Next()
+				return
+			}
+		}
+
+		if username, password, ok := c.Request.BasicAuth(); ok {
+			if user, ok := checkPassword(db, username, password); ok {
+				c.Set("user", user)
+				c.Set("basic_auth", true)
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+	}
+}
+
+func currentUser(c *gin.Context) *models.User {
+	if u, ok := c.Get("user"); ok {
+		return u.(*models.User)
+	}
+	return nil
+}
+
+// currentAPIToken returns the API token the request authenticated with, or
+// nil for sessions and basic auth.
+func currentAPIToken(c *gin.Context) *models.APIToken {
+	if t, ok := c.Get("api_token"); ok {
+		return t.(*models.APIToken)
+	}
+	return nil
+}
+
+// requireTokenAuth refuses HTTP basic credentials. Browsers send cached ones
+// along with requests any page makes, and a WebSocket upgrade isn't held back
+// by CORS, so such routes need a token the page has to know.
+func requireTokenAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("basic_auth") {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "This route needs a session or API token, not basic auth"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func requireRole(role models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil || !user.Role.Allows(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireScope only affects requests made with an API token, which must carry
+// scope. Sessions and basic auth are limited by role alone. Instance-wide
+// admin routes are never reachable with a project token.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := currentAPIToken(c)
+		if t != nil && (!t.HasScope(scope) || (scope == models.ScopeAdmin && t.ProjectID != nil)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token is missing the " + scope + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// canAccessProject reports whether the caller may see projectID. Admins and
+// unrestricted users see every project, restricted users only their own, and
+// project tokens only the project they were issued for.
+func canAccessProject(db *gorm.DB, c *gin.Context, projectID uint) bool {
+	user := currentUser(c)
+	if user == nil {
+		return false
+	}
+	if t := currentAPIToken(c); t != nil && t.ProjectID != nil && *t.ProjectID != projectID {
+		return false
+	}
+	if user.Role == models.RoleAdmin || !user.Restricted {
+		return true
+	}
+	var count int64
+	db.Table("project_members").Where("user_id = ? AND project_id = ?", user.ID, projectID).Count(&count)
+	return count > 0
+}
+
+// projectFilter snapshots which projects the caller can see, for hot paths
+// such as WebSocket broadcasts where a query per message would be too costly.
+func projectFilter(db *gorm.DB, c *gin.Context) func(projectID uint) bool {
+	user := currentUser(c)
+	t := currentAPIToken(c)
+	if (t == nil || t.ProjectID == nil) && (user.Role == models.RoleAdmin || !user.Restricted) {
+		return func(uint) bool { return true }
+	}
+	var ids []uint
+	visibleProjects(db, c).Model(&models.Project{}).Pluck("id", &ids)
+	allowed := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		allowed[id] = true
+	}
+	return func(projectID uint) bool { return allowed[projectID] }
+}
+
+// visibleProjects narrows a project query down to what the caller may see.
+func visibleProjects(db *gorm.DB, c *gin.Context) *gorm.DB {
+	user := currentUser(c)
+	if t := currentAPIToken(c); t != nil && t.ProjectID != nil {
+		db = db.Where("id = ?", *t.ProjectID)
+	}
+	if user.Role == models.RoleAdmin || !user.Restricted {
+		return db
+	}
+	return db.Where("id IN (?)", db.Session(&gorm.Session{NewDB: true}).Table("project_members").Select("project_id").Where("user_id = ?", user.ID))
+}
+
+// requireProjectAccess guards every route with an :id project parameter.
+// Projects the caller cannot see are reported as missing.
+func requireProjectAccess(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil || !canAccessProject(db, c, uint(id)) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireBackupAccess loads the :backupId backup and checks it belongs to a
+// visible project. Instance backups are admin only.
+func requireBackupAccess(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var backup models.Backup
+		if err := db.First(&backup, c.Param("backupId")).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+			return
+		}
+		if backup.Scope == models.BackupScopeInstance {
+			t := currentAPIToken(c)
+			if !currentUser(c).Role.Allows(models.RoleAdmin) || (t != nil && (t.ProjectID != nil || !t.HasScope(models.ScopeAdmin))) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+				return
+			}
+		} else if !canAccessProject(db, c, backup.ProjectID) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+			return
+		}
+		if backup.ProjectID != 0 {
+			c.Set("audit_project_id", backup.ProjectID)
+		}
+		c.Next()
+	}
+}
+
+func registerAuthRoutes(db *gorm.DB, public *gin.RouterGroup, v1 *gin.RouterGroup) {
+	public.POST("/auth/login", func(c *gin.Context) {
+		var req struct {
+			Username string `json:"username" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set("audit_actor", req.Username)
+
+		user, ok := checkPassword(db, req.Username, req.Password)
+		if !ok {
+			c.JSON(401, gin.H{"error": "Invalid username or password"})
+			return
+		}
+
+		token, hash, err := newToken(sessionTokenPrefix)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		session := models.Session{UserID: user.ID, TokenHash: hash, ExpiresAt: time.Now().Add(sessionTTL)}
+		db.Create(&session)
+		db.Where("user_id = ? AND expires_at < ?", user.ID, time.Now()).Delete(&models.Session{})
+
+		c.JSON(200, gin.H{"token": token, "expires_at": session.ExpiresAt, "user": user})
+	})
+
+	v1.POST("/auth/logout", func(c *gin.Context) {
+		if token := requestToken(c); token != "" {
+			db.Where("token_hash = ?", hashToken(token)).Delete(&models.Session{})
+		}
+		c.Status(204)
+	})
+
+	v1.GET("/auth/me", func(c *gin.Context) {
+		c.JSON(200, currentUser(c))
+	})
+
+	admin := v1.Group("/users", requireRole(models.RoleAdmin), requireScope(models.ScopeAdmin))
+
+	admin.GET("", func(c *gin.Context) {
+		var users []models.User
+		db.Preload("Projects").Order("id").Find(&users)
+		c.JSON(200, users)
+	})
+
+	admin.POST("", func(c *gin.Context) {
+		var req userRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Username == "" || req.Password == "" {
+			c.JSON(400, gin.H{"error": "username and password are required"})
+			return
+		}
+
+		var user models.User
+		if err := req.apply(&user); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := db.Create(&user).Error; err != nil {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		auditAfter(c, user)
+		c.JSON(201, user)
+	})
+
+	admin.PUT("/:userId", func(c *gin.Context) {
+		var user models.User
+		if err := db.First(&user, c.Param("userId")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		var req userRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		auditBefore(c, user)
+		if err := req.apply(&user); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := db.Save(&user).Error; err != nil {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Password != "" {
+			// A new password invalidates every existing login.
+			db.Where("user_id = ?", user.ID).Delete(&models.Session{})
+		}
+		after := snapshot(user)
+		if req.Password != "" && after != nil {
+			// The hash is never serialized, so mark the change explicitly.
+			after["password"] = redacted
+		}
+		c.Set("audit_after", after)
+		c.JSON(200, user)
+	})
+
+	admin.PUT("/:userId/projects", func(c *gin.Context) {
+		var user models.User
+		if err := db.First(&user, c.Param("userId")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		var req struct {
+			ProjectIDs []uint `json:"project_ids"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		var projects []models.Project
+		if len(req.ProjectIDs) > 0 {
+			db.Find(&projects, req.ProjectIDs)
+		}
+		if err := db.Model(&user).Association("Projects").Replace(projects); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		user.Projects = projects
+		auditAfter(c, gin.H{"username": user.Username, "project_ids": req.ProjectIDs})
+		c.JSON(200, user)
+	})
+
+	admin.DELETE("/:userId", func(c *gin.Context) {
+		var user models.User
+		if err := db.First(&user, c.Param("userId")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		if user.ID == currentUser(c).ID {
+			c.JSON(400, gin.H{"error": "You cannot delete your own account"})
+			return
+		}
+		auditBefore(c, user)
+		db.Where("user_id = ?", user.ID).Delete(&models.Session{})
+		db.Where("user_id = ?", user.ID).Delete(&models.APIToken{})
+		db.Select("Projects").Delete(&user)
+		c.Status(204)
+	})
+}
+
+type userRequest struct {
+	Username   string      `json:"username"`
+	Password   string      `json:"password"`
+	Role       models.Role `json:"role"`
+	Restricted *bool       `json:"restricted"`
+}
+
+func (r userRequest) apply(user *models.User) error {
+	if r.Username != "" {
+		user.Username = r.Username
+	}
+	if r.Role != "" {
+		if !r.Role.Valid() {
+			return errors.New("role must be one of admin, deployer, viewer")
+		}
+		user.Role = r.Role
+	}
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+	if r.Restricted != nil {
+		user.Restricted = *r.Restricted
+	}
+	if r.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(r.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = string(hash)
+	}
+	return nil
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"github.com/timuzkas/orchestro/api/storage"
+	"gorm.io/gorm"
+)
+
+const (
+	projectExportFormat = 1
+	backupHookTimeout   = 10 * time.Minute
+)
+
+// projectExport is the portable description of a single project stored as
+// project.json at the root of a project backup archive.
+type projectExport struct {
+	Format     int            `json:"format"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Project    models.Project `json:"project"`
+}
+
+// handleBackup exports a single project: its settings, env vars, volume
+// definitions and deployment history as project.json, plus the contents of
+// its volumes. No other project's data ends up in the archive.
+func handleBackup(db *gorm.DB, orch *orchestrator.DockerOrchestrator, stores *storage.Registry, enc *backupEncryption, projectID uint) (models.Backup, error) {
+	var project models.Project
+	if err := db.Preload("EnvVars").Preload("Volumes").Preload("Addons").Preload("Deployments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id DESC")
+	}).First(&project, projectID).Error; err != nil {
+		return models.Backup{}, fmt.Errorf("failed to load project: %v", err)
+	}
+
+	// Build logs can be huge and are not needed to recreate a project.
+	project.Backups = nil
+	for i := range project.Deployments {
+		project.Deployments[i].Logs = ""
+	}
+
+	manifest, err := json.MarshalIndent(projectExport{
+		Format:     projectExportFormat,
+		ExportedAt: time.Now(),
+		Project:    project,
+	}, "", "  ")
+	if err != nil {
+		return models.Backup{}, fmt.Errorf("failed to encode project: %v", err)
+	}
+
+	mode := project.BackupMode
+	if mode == "" {
+		mode = models.BackupModeLive
+	}
+	resume, err := quiesceProject(db, orch, project, mode)
+	if err != nil {
+		return models.Backup{}, err
+	}
+	defer resume()
+
+	timestamp := time.Now().Format("20060102-150405")
+	key := fmt.Sprintf("backup-%d-%s.tar.gz", project.ID, timestamp)
+	record := models.Backup{ProjectID: project.ID, Scope: models.BackupScopeProject, Mode: mode}
+
+	backup, err := storeBackup(db, stores, enc, record, key, func(tw *tar.Writer) error {
+		if err := addBytesToTar(tw, "project.json", manifest); err != nil {
+			return err
+		}
+		for _, v := range project.Volumes {
+			if err := addVolumeToTar(tw, orch, v, fmt.Sprintf("volumes/%d", v.ID)); err != nil {
+				return err
+			}
+		}
+		for _, a := range project.Addons {
+			if err := addAddonToTar(tw, orch, a, "addons/"+a.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Backup{}, err
+	}
+
+	applyBackupRetention(db, stores, backup)
+	return backup, nil
+}
+
+// quiesceProject gets the project's running container into a state where its
+// volumes can be archived consistently, according to mode. The returned
+// function undoes it and must always be called once the archive is written.
+func quiesceProject(db *gorm.DB, orch *orchestrator.DockerOrchestrator, project models.Project, mode models.BackupMode) (func(), error) {
+	noop := func() {}
+	if !mode.Valid() {
+		return noop, fmt.Errorf("unknown backup mode %q", mode)
+	}
+	if mode == models.BackupModeLive || len(project.Volumes) == 0 {
+		return noop, nil
+	}
+
+	var deployment models.Deployment
+	db.Where("project_id = ? AND container_id != ''", project.ID).Order("id DESC").First(&deployment)
+	if deployment.ID == 0 {
+		return noop, nil
+	}
+	// A stopped container is not writing anything, so there is nothing to do.
+	// Replicas share the volumes, so all of them are paused or stopped; hooks
+	// run in the first one only.
+	var running []string
+	for _, id := range deployment.ContainerIDs() {
+		if state, err := orch.GetContainerStatus(context.Background(), id); err == nil && state == "running" {
+			running = append(running, id)
+		}
+	}
+	if len(running) == 0 {
+		return noop, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backupHookTimeout)
+	defer cancel()
+
+	switch mode {
+	case models.BackupModePause:
+		var paused []string
+		resume := func() {
+			for _, id := range paused {
+				if err := orch.UnpauseContainer(context.Background(), id); err != nil {
+					fmt.Printf("Backup: failed to unpause container %s: %v\n", id, err)
+				}
+			}
+		}
+		for _, id := range running {
+			fmt.Printf("Backup: pausing container %s for project %d\n", id, project.ID)
+			if err := orch.PauseContainer(ctx, id); err != nil {
+				resume()
+				return noop, fmt.Errorf("failed to pause container: %v", err)
+			}
+			paused = append(paused, id)
+		}
+		return resume, nil
+
+	case models.BackupModeStop:
+		var stopped []string
+		restart := func() {
+			for _, id := range stopped {
+				if err := orch.StartContainer(context.Background(), id); err != nil {
+					fmt.Printf("Backup: failed to restart container %s: %v\n", id, err)
+				}
+			}
+		}
+		for _, id := range running {
+			fmt.Printf("Backup: stopping container %s for project %d\n", id, project.ID)
+			if err := orch.StopContainer(ctx, id); err != nil {
+				restart()
+				return noop, fmt.Errorf("failed to stop container: %v", err)
+			}
+			stopped = append(stopped, id)
+		}
+		return restart, nil
+
+	case models.BackupModeHook:
+		containerID := running[0]
+		if project.BackupPreHook != "" {
+			if err := runBackupHook(ctx, orch, containerID, "pre-backup", project.BackupPreHook); err != nil {
+				return noop, err
+			}
+		}
+		return func() {
+			if project.BackupPostHook == "" {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), backupHookTimeout)
+			defer cancel()
+			if err := runBackupHook(ctx, orch, containerID, "post-backup", project.BackupPostHook); err != nil {
+				fmt.Printf("Backup: %v\n", err)
+			}
+		}, nil
+	}
+	return noop, nil
+}
+
+func runBackupHook(ctx context.Context, orch *orchestrator.DockerOrchestrator, containerID string, name string, command string) error {
+	fmt.Printf("Backup: running %s hook in %s: %s\n", name, containerID, command)
+	output, exitCode, err := orch.ExecInContainer(ctx, containerID, command)
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %v", name, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s hook exited with code %d: %s", name, exitCode, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// handleInstanceBackup snapshots the whole database together with the volumes
+// of every project. It is meant for disaster recovery of the entire host.
+func handleInstanceBackup(db *gorm.DB, orch *orchestrator.DockerOrchestrator, stores *storage.Registry, enc *backupEncryption) (models.Backup, error) {
+	timestamp := time.Now().Format("20060102-150405")
+	tempDbPath := filepath.Join(os.TempDir(), fmt.Sprintf("orchestro-%s.db", timestamp))
+	key := fmt.Sprintf("backup-instance-%s.tar.gz", timestamp)
+
+	if err := db.Exec(fmt.Sprintf("VACUUM INTO '%s'", tempDbPath)).Error; err != nil {
+		return models.Backup{}, fmt.Errorf("failed to vacuum database: %v", err)
+	}
+	defer os.Remove(tempDbPath)
+
+	var volumes []models.Volume
+	db.Find(&volumes)
+	var addons []models.Addon
+	db.Find(&addons)
+
+	record := models.Backup{Scope: models.BackupScopeInstance, Mode: models.BackupModeLive}
+	backup, err := storeBackup(db, stores, enc, record, key, func(tw *tar.Writer) error {
+		if err := addPathToTar(tw, tempDbPath, "orchestro.db"); err != nil {
+			return err
+		}
+		for _, v := range volumes {
+			if err := addVolumeToTar(tw, orch, v, fmt.Sprintf("volumes/%d/%d", v.ProjectID, v.ID)); err != nil {
+				return err
+			}
+		}
+		for _, a := range addons {
+			if err := addAddonToTar(tw, orch, a, fmt.Sprintf("addons/%d/%s", a.ProjectID, a.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Backup{}, err
+	}
+
+	applyBackupRetention(db, stores, backup)
+	return backup, nil
+}
+
+// storeBackup streams a gzipped tarball, encrypted when a key is configured,
+// straight into the default backup target and records it together with the
+// SHA-256 of the stored bytes. Nothing is staged on local disk first.
+func storeBackup(db *gorm.DB, stores *storage.Registry, enc *backupEncryption, backup models.Backup, key string, fill func(tw *tar.Writer) error) (models.Backup, error) {
+	store, err := stores.Get(stores.Default)
+	if err != nil {
+		return models.Backup{}, err
+	}
+	if enc != nil {
+		key += ".age"
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBackupStream(pw, enc, fill))
+	}()
+
+	hashed := newHashingReader(pr)
+	_, err = store.Put(context.Background(), key, hashed)
+	// Unblocks the writer if the store gave up before reading everything.
+	pr.CloseWithError(err)
+	if err != nil {
+		return models.Backup{}, fmt.Errorf("failed to create backup tar: %v", err)
+	}
+
+	backup.CreatedAt = time.Now()
+	backup.Target = store.Name()
+	backup.FilePath = key
+	backup.Size = hashed.n
+	backup.SHA256 = hashed.sum()
+	backup.Encrypted = enc != nil
+	if err := db.Create(&backup).Error; err != nil {
+		store.Delete(context.Background(), key)
+		return models.Backup{}, err
+	}
+	return backup, nil
+}
+
+func writeBackupStream(w io.Writer, enc *backupEncryption, fill func(tw *tar.Writer) error) error {
+	if enc == nil {
+		return writeTarGz(w, fill)
+	}
+	ew, err := enc.encrypt(w)
+	if err != nil {
+		return fmt.Errorf("failed to start encryption: %v", err)
+	}
+	if err := writeTarGz(ew, fill); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// verifyBackup re-reads a stored archive, compares its SHA-256 with the one
+// recorded at creation and, when possible, decrypts and walks the tarball to
+// make sure it is readable to the end. The outcome is saved on the backup.
+func verifyBackup(ctx context.Context, db *gorm.DB, stores *storage.Registry, enc *backupEncryption, backup *models.Backup) error {
+	verr := checkBackupArchive(ctx, stores, enc, backup)
+
+	now := time.Now()
+	backup.VerifiedAt = &now
+	backup.Integrity = models.IntegrityOK
+	if verr != nil {
+		backup.Integrity = models.IntegrityCorrupted
+	}
+	db.Save(backup)
+	return verr
+}
+
+func checkBackupArchive(ctx context.Context, stores *storage.Registry, enc *backupEncryption, backup *models.Backup) error {
+	store, err := stores.Get(backup.Target)
+	if err != nil {
+		return err
+	}
+	reader, err := store.Open(ctx, backup.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %v", err)
+	}
+	defer reader.Close()
+
+	hashed := newHashingReader(reader)
+	var archive io.Reader = hashed
+	if backup.Encrypted {
+		if enc == nil {
+			// Without the key only the checksum can be checked.
+			archive = nil
+		} else if archive, err = enc.decrypt(hashed); err != nil {
+			return fmt.Errorf("failed to decrypt backup: %v", err)
+		}
+	}
+
+	if archive != nil {
+		if err := readTarGz(archive); err != nil {
+			return fmt.Errorf("archive is unreadable: %v", err)
+		}
+	}
+	// Drain whatever the tar reader did not consume so the hash covers the file.
+	if _, err := io.Copy(io.Discard, hashed); err != nil {
+		return fmt.Errorf("failed to read backup: %v", err)
+	}
+
+	if hashed.n != backup.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", backup.Size, hashed.n)
+	}
+	if backup.SHA256 != "" && hashed.sum() != backup.SHA256 {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", backup.SHA256, hashed.sum())
+	}
+	if backup.SHA256 == "" {
+		// Backups taken before checksums existed get one on first verification.
+		backup.SHA256 = hashed.sum()
+	}
+	return nil
+}
+
+func readTarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return err
+		}
+	}
+	return gz.Close()
+}
+
+// applyBackupRetention keeps the BACKUP_RETENTION most recent backups of the
+// same project and scope on the target the new backup was written to, and
+// removes older ones from both the target and the database.
+func applyBackupRetention(db *gorm.DB, stores *storage.Registry, latest models.Backup) {
+	keep, _ := strconv.Atoi(os.Getenv("BACKUP_RETENTION"))
+	if keep <= 0 {
+		return
+	}
+
+	var expired []models.Backup
+	db.Where("project_id = ? AND scope = ? AND target = ?", latest.ProjectID, latest.Scope, latest.Target).
+		Order("id DESC").Offset(keep).Find(&expired)
+
+	store, err := stores.Get(latest.Target)
+	if err != nil {
+		return
+	}
+	for _, b := range expired {
+		fmt.Printf("Retention: removing backup %d (%s) from %s\n", b.ID, b.FilePath, b.Target)
+		if err := store.Delete(context.Background(), b.FilePath); err != nil {
+			fmt.Printf("Retention: failed to remove %s: %v\n", b.FilePath, err)
+			continue
+		}
+		db.Delete(&b)
+	}
+}
+
+// deleteBackup removes the archive from its target and then the record. The
+// record is kept when the file cannot be removed so it can be retried.
+func deleteBackup(ctx context.Context, db *gorm.DB, stores *storage.Registry, backup models.Backup) error {
+	store, err := stores.Get(backup.Target)
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(ctx, backup.FilePath); err != nil {
+		return fmt.Errorf("failed to delete backup file: %v", err)
+	}
+	return db.Delete(&backup).Error
+}
+
+// hashingReader counts and hashes everything read through it.
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (c *hashingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *hashingReader) sum() string {
+	return hex.EncodeToString(c.h.Sum(nil))
+}
+
+func writeTarGz(w io.Writer, fill func(tw *tar.Writer) error) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := fill(tw); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addBytesToTar(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// addVolumeToTar archives a volume under prefix. Bind mounts are read from the
+// host; named volumes are exported through a helper container.
+func addVolumeToTar(tw *tar.Writer, orch *orchestrator.DockerOrchestrator, v models.Volume, prefix string) error {
+	if err := checkVolume(&v); err != nil {
+		return err
+	}
+	if v.Type != models.VolumeTypeNamed {
+		return addPathToTar(tw, v.HostPath, prefix)
+	}
+
+	stream, err := orch.ExportVolume(context.Background(), v.Name)
+	if err != nil {
+		return fmt.Errorf("failed to export volume %s: %v", v.Name, err)
+	}
+	defer stream.Close()
+
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		hdr.Name = prefix + strings.TrimPrefix(hdr.Name, "volume")
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// addAddonToTar stores a dump of the add-on's data under prefix, made with its
+// own tool (pg_dump, mysqldump, redis-cli) while it keeps running. The dump is
+// staged in a temp file because tar needs the size up front.
+func addAddonToTar(tw *tar.Writer, orch *orchestrator.DockerOrchestrator, addon models.Addon, prefix string) error {
+	tmp, err := os.CreateTemp("", "orchestro-addon-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), backupHookTimeout)
+	defer cancel()
+	if err := dumpAddon(ctx, orch, addon, tmp); err != nil {
+		return err
+	}
+	return addPathToTar(tw, tmp.Name(), prefix+"/"+addonTemplates[addon.Type].dumpFile)
+}
+
+// addPathToTar stores src (a file or a directory tree) under prefix inside the
+// archive. Missing paths are skipped, matching how volumes that were never
+// created on the host used to be ignored.
+func addPathToTar(tw *tar.Writer, src string, prefix string) error {
+	if _, err := os.Lstat(src); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		name := prefix
+		if rel != "." {
+			name = prefix + "/" + filepath.ToSlash(rel)
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/timuzkas/orchestro/api/models"
+)
+
+const (
+	defaultBuildTimeout        = 30 * time.Minute
+	defaultMaxConcurrentBuilds = 2
+)
+
+var (
+	buildTimeout = defaultBuildTimeout
+	builds       *buildQueue
+
+	errSuperseded = errors.New("superseded by a newer deployment")
+
+	// deploymentCancels holds the cancel funcs of every deploy in progress,
+	// running or waiting, by project.
+	deploymentCancels = make(map[uint]map[*activeDeploy]bool)
+	cancelMutex       sync.Mutex
+)
+
+type activeDeploy struct {
+	cancel context.CancelFunc
+}
+
+// startDeploy gives a new deploy of the project a context the cancel route
+// can stop. The returned func forgets it again once the deploy is over.
+// Deploys don't cancel each other; the build queue decides which one runs.
+func startDeploy(projectID uint) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &activeDeploy{cancel: cancel}
+	cancelMutex.Lock()
+	if deploymentCancels[projectID] == nil {
+		deploymentCancels[projectID] = make(map[*activeDeploy]bool)
+	}
+	deploymentCancels[projectID][d] = true
+	cancelMutex.Unlock()
+
+	return ctx, func() {
+		cancelMutex.Lock()
+		delete(deploymentCancels[projectID], d)
+		if len(deploymentCancels[projectID]) == 0 {
+			delete(deploymentCancels, projectID)
+		}
+		cancelMutex.Unlock()
+		cancel()
+	}
+}
+
+// cancelDeploys stops every deploy of the project in progress and reports
+// whether there was one.
+func cancelDeploys(projectID uint) bool {
+	cancelMutex.Lock()
+	defer cancelMutex.Unlock()
+	deploys := deploymentCancels[projectID]
+	for d := range deploys {
+		d.cancel()
+	}
+	delete(deploymentCancels, projectID)
+	return len(deploys) > 0
+}
+
+// deploying reports whether a deploy of the project is in progress.
+func deploying(projectID uint) bool {
+	cancelMutex.Lock()
+	defer cancelMutex.Unlock()
+	return len(deploymentCancels[projectID]) > 0
+}
+
+// loadBuildLimits reads BUILD_TIMEOUT_MINUTES and MAX_CONCURRENT_BUILDS.
+func loadBuildLimits() error {
+	if value := os.Getenv("BUILD_TIMEOUT_MINUTES"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes <= 0 {
+			return fmt.Errorf("BUILD_TIMEOUT_MINUTES must be a positive number of minutes, got %q", value)
+		}
+		buildTimeout = time.Duration(minutes) * time.Minute
+	}
+	limit := defaultMaxConcurrentBuilds
+	if value := os.Getenv("MAX_CONCURRENT_BUILDS"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return fmt.Errorf("MAX_CONCURRENT_BUILDS must be a positive number, got %q", value)
+		}
+	}
+	builds = newBuildQueue(limit)
+	return nil
+}
+
+// projectBuildTimeout is how long one of the project's builds may take.
+func projectBuildTimeout(project models.Project) time.Duration {
+	if project.BuildTimeout > 0 {
+		return time.Duration(project.BuildTimeout) * time.Second
+	}
+	return buildTimeout
+}
+
+// buildQueue limits how many builds run at once. A project deploys one
+// deployment at a time, from its build through its cutover, and keeps at
+// most one more waiting: a deploy that arrives while another is waiting
+// takes its place, so a burst of pushes builds the first and the last.
+type buildQueue struct {
+	mu      sync.Mutex
+	slots   chan struct{}
+	waiting map[uint]chan struct{} // closed when the waiting deploy is superseded
+	running map[uint]chan struct{} // closed when the running deploy is done
+}
+
+func newBuildQueue(limit int) *buildQueue {
+	return &buildQueue{
+		slots:   make(chan struct{}, limit),
+		waiting: make(map[uint]chan struct{}),
+		running: make(map[uint]chan struct{}),
+	}
+}
+
+// buildLease is a deploy's turn in the queue. It holds one of the build
+// slots until the build is over and the project until the deploy is.
+type buildLease struct {
+	q         *buildQueue
+	projectID uint
+	done      chan struct{}
+	slotOnce  sync.Once
+	doneOnce  sync.Once
+}
+
+// releaseSlot lets another build start. The project stays locked, so its
+// next deploy still waits for this one's release command and rollout.
+func (l *buildLease) releaseSlot() {
+	l.slotOnce.Do(func() { <-l.q.slots })
+}
+
+// release ends the deploy's turn.
+func (l *buildLease) release() {
+	l.releaseSlot()
+	l.doneOnce.Do(func() {
+		l.q.mu.Lock()
+		close(l.done)
+		delete(l.q.running, l.projectID)
+		l.q.mu.Unlock()
+	})
+}
+
+// acquire waits until the project's deploy may start building. It fails
+// with errSuperseded when a newer deploy of the project takes its place in
+// the queue, or with the context's error.
+func (q *buildQueue) acquire(ctx context.Context, projectID uint) (*buildLease, error) {
+	q.mu.Lock()
+	if prev, ok := q.waiting[projectID]; ok {
+		close(prev)
+	}
+	superseded := make(chan struct{})
+	q.waiting[projectID] = superseded
+	q.mu.Unlock()
+
+	giveUp := func(err error) (*buildLease, error) {
+		q.mu.Lock()
+		if q.waiting[projectID] == superseded {
+			delete(q.waiting, projectID)
+		}
+		q.mu.Unlock()
+		return nil, err
+	}
+
+	for {
+		q.mu.Lock()
+		busy := q.running[projectID]
+		q.mu.Unlock()
+		if busy != nil {
+			select {
+			case <-busy:
+				continue
+			case <-superseded:
+				return nil, errSuperseded
+			case <-ctx.Done():
+				return giveUp(ctx.Err())
+			}
+		}
+
+		select {
+		case q.slots <- struct{}{}:
+		case <-superseded:
+			return nil, errSuperseded
+		case <-ctx.Done():
+			return giveUp(ctx.Err())
+		}
+
+		q.mu.Lock()
+		select {
+		case <-superseded:
+			q.mu.Unlock()
+			<-q.slots
+			return nil, errSuperseded
+		default:
+		}
+		if q.running[projectID] != nil {
+			q.mu.Unlock()
+			<-q.slots
+			continue
+		}
+		done := make(chan struct{})
+		q.running[projectID] = done
+		delete(q.waiting, projectID)
+		q.mu.Unlock()
+
+		return &buildLease{q: q, projectID: projectID, done: done}, nil
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moby/patternmatcher"
+	"github.com/timuzkas/orchestro/api/models"
+	"gorm.io/gorm"
+)
+
+const (
+	maxBuildExcludes = 100
+	maxBuildArgs     = 100
+	maxBuildTimeout  = 24 * 60 * 60
+)
+
+var (
+	buildArgPattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	buildTargetPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+)
+
+// checkBuildSettings validates how a project is built before it is saved.
+func checkBuildSettings(project *models.Project) error {
+	if len(project.BuildExcludes) > maxBuildExcludes {
+		return fmt.Errorf("a project can have at most %d build excludes", maxBuildExcludes)
+	}
+	var excludes []string
+	for _, pattern := range project.BuildExcludes {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			excludes = append(excludes, pattern)
+		}
+	}
+	if _, err := patternmatcher.New(excludes); err != nil {
+		return fmt.Errorf("invalid build exclude: %v", err)
+	}
+	project.BuildExcludes = excludes
+
+	var err error
+	if project.DockerfilePath, err = repoPath("dockerfile_path", project.DockerfilePath); err != nil {
+		return err
+	}
+	if project.BuildContext, err = repoPath("build_context", project.BuildContext); err != nil {
+		return err
+	}
+	if project.RootDirectory, err = repoPath("root_directory", project.RootDirectory); err != nil {
+		return err
+	}
+	if project.BuildTimeout < 0 || project.BuildTimeout > maxBuildTimeout {
+		return fmt.Errorf("build_timeout_seconds must be between 0 and %d", maxBuildTimeout)
+	}
+	if project.BuildTarget != "" && !buildTargetPattern.MatchString(project.BuildTarget) {
+		return fmt.Errorf("build_target %q is not a valid stage name", project.BuildTarget)
+	}
+	if len(project.BuildArgs) > maxBuildArgs {
+		return fmt.Errorf("a project can have at most %d build args", maxBuildArgs)
+	}
+	for key := range project.BuildArgs {
+		if !buildArgPattern.MatchString(key) {
+			return fmt.Errorf("build arg %q must be letters, digits and '_'", key)
+		}
+		// Dynamic loader variables have no use in a build and could only
+		// ever reach a process on the host.
+		if upper := strings.ToUpper(key); strings.HasPrefix(upper, "LD_") || strings.HasPrefix(upper, "DYLD_") {
+			return fmt.Errorf("build arg %q is not allowed", key)
+		}
+	}
+	return nil
+}
+
+// repoPath cleans a path that must stay inside the repository. "." and ""
+// both mean the repository root and come back as "".
+func repoPath(field, p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", nil
+	}
+	if path.IsAbs(p) || strings.Contains(p, "\\") {
+		return "", fmt.Errorf("%s must be a path relative to the repository", field)
+	}
+	p = path.Clean(p)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("%s must stay inside the repository", field)
+	}
+	if p == "." {
+		return "", nil
+	}
+	return p, nil
+}
+
+// packageCaches are the package managers whose downloads are kept in
+// BuildKit cache mounts between builds, and the variables that point them at
+// the mount.
+var packageCaches = []struct {
+	tool string
+	env  map[string]string
+}{
+	{"bun", map[string]string{"BUN_INSTALL_CACHE_DIR": "/cache/bun"}},
+	{"npm", map[string]string{"npm_config_cache": "/cache/npm"}},
+	{"pnpm", map[string]string{"npm_config_store_dir": "/cache/pnpm"}},
+	{"yarn", map[string]string{"YARN_CACHE_FOLDER": "/cache/yarn"}},
+	{"go", map[string]string{"GOCACHE": "/cache/go-build", "GOMODCACHE": "/cache/go-mod"}},
+	{"pip", map[string]string{"PIP_CACHE_DIR": "/cache/pip"}},
+	{"pip3", map[string]string{"PIP_CACHE_DIR": "/cache/pip"}},
+}
+
+// withPackageCaches prefixes a RUN command with cache mounts for the package
+// managers it calls, "bun install" becoming
+// "--mount=type=cache,... export BUN_INSTALL_CACHE_DIR=/cache/bun && bun install".
+// Mounts are per project, so clearing one project's cache leaves the others.
+func withPackageCaches(project models.Project, command string, buildKit bool) string {
+	if !buildKit {
+		return command
+	}
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(command, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) {
+		words[word] = true
+	}
+
+	var mounts, exports []string
+	seen := make(map[string]bool)
+	for _, cache := range packageCaches {
+		if !words[cache.tool] {
+			continue
+		}
+		// Sorted, since a RUN line that changes misses the layer cache.
+		names := make([]string, 0, len(cache.env))
+		for name := range cache.env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			dir := cache.env[name]
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			id := fmt.Sprintf("orchestro-p%d-c%d-%s", project.ID, project.CacheVersion, path.Base(dir))
+			mounts = append(mounts, fmt.Sprintf("--mount=type=cache,id=%s,target=%s", id, dir))
+			exports = append(exports, name+"="+dir)
+		}
+	}
+	if len(mounts) == 0 {
+		return command
+	}
+	return fmt.Sprintf("%s export %s && %s", strings.Join(mounts, " "), strings.Join(exports, " "), command)
+}
+
+// buildCacheDir holds a project's BuildKit layer cache.
+func buildCacheDir(projectID uint) string {
+	return filepath.Join("data", "buildcache", fmt.Sprintf("%d", projectID))
+}
+
+func registerBuildRoutes(db *gorm.DB, v1 *gin.RouterGroup, projectAccess gin.HandlerFunc) {
+	deployer := requireRole(models.RoleDeployer)
+	deployScope := requireScope(models.ScopeDeployWrite)
+
+	// Drops the project's layer cache and moves it to fresh package cache
+	// mounts, for when a cached layer is broken. The next build is a cold one.
+	v1.DELETE("/projects/:id/build-cache", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		var project models.Project
+		if err := db.First(&project, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+
+		size, _ := dirSize(buildCacheDir(project.ID))
+		if err := os.RemoveAll(buildCacheDir(project.ID)); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to clear build cache: " + err.Error()})
+			return
+		}
+		db.Model(&project).Update("cache_version", project.CacheVersion+1)
+
+		auditAfter(c, gin.H{"reclaimed": size})
+		c.JSON(200, gin.H{"message": "Build cache cleared", "reclaimed": size})
+	})
+}
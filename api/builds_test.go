@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRepoPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{"empty", "", "", ""},
+		{"blank", "  ", "", ""},
+		{"dot", ".", "", ""},
+		{"dot slash", "./", "", ""},
+		{"plain", "app", "app", ""},
+		{"trimmed", " app ", "app", ""},
+		{"nested", "apps/web/", "apps/web", ""},
+		{"dot dot that stays inside", "apps/../web", "web", ""},
+		{"dot segments", "./apps/./web", "apps/web", ""},
+		{"dot dot back to the root", "apps/..", "", ""},
+		{"parent", "..", "", "must stay inside"},
+		{"above the root", "../other", "", "must stay inside"},
+		{"dot dot out of a subdirectory", "apps/../../other", "", "must stay inside"},
+		{"absolute", "/etc", "", "must be a path relative"},
+		{"backslash", `apps\web`, "", "must be a path relative"},
+		{"backslash dot dot", `..\other`, "", "must be a path relative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repoPath("build_context", tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("repoPath(%q) error = %v, want one containing %q", tt.path, err, tt.wantErr)
+				}
+				if !strings.HasPrefix(err.Error(), "build_context ") {
+					t.Fatalf("repoPath(%q) error %q does not name the field", tt.path, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("repoPath(%q): %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Fatalf("repoPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultCronTimeout = 10 * time.Minute
+	// cronRunHistory is how many runs are kept per job.
+	cronRunHistory = 50
+	// maxCronLogBytes caps the output stored per run; the tail is kept.
+	maxCronLogBytes = 256 * 1024
+)
+
+// cronScheduler runs project cron jobs in-process. Schedules live in the
+// database and are loaded on start; every change through the API calls sync.
+type cronScheduler struct {
+	db      *gorm.DB
+	orch    *orchestrator.DockerOrchestrator
+	cron    *cron.Cron
+	mu      sync.Mutex
+	entries map[uint]cron.EntryID
+	// running holds a cancel function per in-flight run, by job.
+	running map[uint]map[uint]context.CancelFunc
+}
+
+func newCronScheduler(db *gorm.DB, orch *orchestrator.DockerOrchestrator) *cronScheduler {
+	return &cronScheduler{
+		db:      db,
+		orch:    orch,
+		cron:    cron.New(),
+		entries: make(map[uint]cron.EntryID),
+		running: make(map[uint]map[uint]context.CancelFunc),
+	}
+}
+
+func (s *cronScheduler) start() {
+	// Runs that were in flight when the API stopped will never report back.
+	now := time.Now()
+	s.db.Model(&models.CronRun{}).Where("status = ?", models.CronRunRunning).Updates(map[string]interface{}{
+		"status":      models.CronRunFailed,
+		"finished_at": now,
+		"logs":        gorm.Expr("COALESCE(logs, '') || ?", "\nInterrupted by an API restart."),
+	})
+
+	var jobs []models.CronJob
+	s.db.Find(&jobs)
+	for _, job := range jobs {
+		s.sync(job)
+	}
+	s.cron.Start()
+}
+
+// sync (re)schedules a job after it was created or changed.
+func (s *cronScheduler) sync(job models.CronJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.entries[job.ID]; ok {
+		s.cron.Remove(id)
+		delete(s.entries, job.ID)
+	}
+	if !job.Enabled {
+		return
+	}
+
+	jobID := job.ID
+	id, err := s.cron.AddFunc(job.Schedule, func() { s.trigger(jobID, "schedule") })
+	if err != nil {
+		fmt.Printf("Failed to schedule cron job %d: %v\n", job.ID, err)
+		return
+	}
+	s.entries[job.ID] = id
+}
+
+// remove unschedules a job and stops its runs.
+func (s *cronScheduler) remove(jobID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.entries[jobID]; ok {
+		s.cron.Remove(id)
+		delete(s.entries, jobID)
+	}
+	for _, cancel := range s.running[jobID] {
+		cancel()
+	}
+}
+
+// removeProject deletes every cron job of a project with its history.
+func (s *cronScheduler) removeProject(projectID uint) {
+	var jobs []models.CronJob
+	s.db.Where("project_id = ?", projectID).Find(&jobs)
+	for _, job := range jobs {
+		s.remove(job.ID)
+	}
+	s.db.Where("project_id = ?", projectID).Delete(&models.CronRun{})
+	s.db.Where("project_id = ?", projectID).Delete(&models.CronJob{})
+}
+
+func (s *cronScheduler) nextRun(jobID uint) *time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.entries[jobID]
+	if !ok {
+		return nil
+	}
+	next := s.cron.Entry(id).Next
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// trigger starts a run of the job, applying its overlap policy. The job is
+// reloaded so the run uses its current command and settings.
+func (s *cronScheduler) trigger(jobID uint, trigger string) (models.CronRun, error) {
+	var job models.CronJob
+	if err := s.db.First(&job, jobID).Error; err != nil {
+		return models.CronRun{}, err
+	}
+
+	run := models.CronRun{
+		CronJobID: job.ID,
+		ProjectID: job.ProjectID,
+		Trigger:   trigger,
+		Status:    models.CronRunRunning,
+		StartedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	inFlight := len(s.running[job.ID])
+	if inFlight > 0 && job.OverlapPolicy == models.CronOverlapForbid {
+		s.mu.Unlock()
+		run.Status = models.CronRunSkipped
+		run.FinishedAt = &run.StartedAt
+		run.Logs = "Skipped: the previous run is still going."
+		s.db.Create(&run)
+		return run, nil
+	}
+	if inFlight > 0 && job.OverlapPolicy == models.CronOverlapReplace {
+		for _, cancel := range s.running[job.ID] {
+			cancel()
+		}
+	}
+
+	timeout := time.Duration(job.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultCronTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	s.db.Create(&run)
+	if s.running[job.ID] == nil {
+		s.running[job.ID] = make(map[uint]context.CancelFunc)
+	}
+	s.running[job.ID][run.ID] = cancel
+	s.mu.Unlock()
+
+	s.db.Model(&job).Update("last_run_at", run.StartedAt)
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running[job.ID], run.ID)
+			s.mu.Unlock()
+			cancel()
+		}()
+		s.execute(ctx, job, &run, timeout)
+	}()
+	return run, nil
+}
+
+func (s *cronScheduler) execute(ctx context.Context, job models.CronJob, run *models.CronRun, timeout time.Duration) {
+	var logs tailBuffer
+	finish := func(status models.CronRunStatus, exitCode *int, note string) {
+		now := time.Now()
+		if note != "" {
+			logs.WriteString(note)
+		}
+		s.db.Model(run).Updates(map[string]interface{}{
+			"status":      status,
+			"exit_code":   exitCode,
+			"finished_at": now,
+			"logs":        logs.String(),
+		})
+		s.pruneHistory(job.ID)
+	}
+
+	var project models.Project
+	if err := s.db.Preload("EnvVars").Preload("Volumes").Preload("Addons").First(&project, job.ProjectID).Error; err != nil {
+		finish(models.CronRunFailed, nil, "Project not found.")
+		return
+	}
+	for i := range project.Volumes {
+		if err := checkVolume(&project.Volumes[i]); err != nil {
+			finish(models.CronRunFailed, nil, "Refusing to mount volume: "+err.Error())
+			return
+		}
+	}
+	if err := ensureNamedVolumes(ctx, s.orch, project.Volumes); err != nil {
+		finish(models.CronRunFailed, nil, err.Error())
+		return
+	}
+	if err := ensureProjectNetworks(ctx, s.orch, project); err != nil {
+		finish(models.CronRunFailed, nil, err.Error())
+		return
+	}
+
+	imageName, err := deployedImage(s.db, project.ID)
+	if err != nil {
+		finish(models.CronRunFailed, nil, "Nothing to run: "+err.Error()+".")
+		return
+	}
+	containerName := fmt.Sprintf("orchestro-cron-p%d-j%d-%d", project.ID, job.ID, run.ID)
+	exitCode, err := s.orch.RunOneOff(ctx, imageName, containerName, job.Command, projectEnv(project), projectBinds(project), projectNetworks(project, false), func(out string) {
+		logs.WriteString(out)
+	})
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		finish(models.CronRunTimedOut, nil, fmt.Sprintf("\nTimed out after %s.", timeout))
+	case ctx.Err() == context.Canceled:
+		finish(models.CronRunCancelled, nil, "\nCancelled.")
+	case err != nil:
+		finish(models.CronRunFailed, nil, "\n"+err.Error())
+	case exitCode != 0:
+		finish(models.CronRunFailed, &exitCode, "")
+	default:
+		finish(models.CronRunSucceeded, &exitCode, "")
+	}
+}
+
+// pruneHistory keeps the newest cronRunHistory runs of a job.
+func (s *cronScheduler) pruneHistory(jobID uint) {
+	var keep []uint
+	s.db.Model(&models.CronRun{}).Where("cron_job_id = ?", jobID).Order("id DESC").Limit(cronRunHistory).Pluck("id", &keep)
+	if len(keep) == cronRunHistory {
+		s.db.Where("cron_job_id = ? AND id NOT IN ?", jobID, keep).Delete(&models.CronRun{})
+	}
+}
+
+// tailBuffer keeps the last maxCronLogBytes written to it. Output arrives from
+// one goroutine at a time but finish may read it from another, hence the lock.
+type tailBuffer struct {
+	mu        sync.Mutex
+	buf       strings.Builder
+	truncated bool
+}
+
+func (b *tailBuffer) WriteString(s string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.WriteString(s)
+	if b.buf.Len() > maxCronLogBytes {
+		tail := b.buf.String()[b.buf.Len()-maxCronLogBytes:]
+		b.buf.Reset()
+		b.buf.WriteString(tail)
+		b.truncated = true
+	}
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.truncated {
+		return "[earlier output truncated]\n" + b.buf.String()
+	}
+	return b.buf.String()
+}
+
+type cronJobRequest struct {
+	Name           *string                   `json:"name"`
+	Schedule       *string                   `json:"schedule"`
+	Command        *string                   `json:"command"`
+	TimeoutSeconds *int                      `json:"timeout_seconds"`
+	OverlapPolicy  *models.CronOverlapPolicy `json:"overlap_policy"`
+	Enabled        *bool                     `json:"enabled"`
+}
+
+func (r cronJobRequest) apply(job *models.CronJob) error {
+	if r.Name != nil {
+		job.Name = *r.Name
+	}
+	if r.Schedule != nil {
+		job.Schedule = strings.TrimSpace(*r.Schedule)
+	}
+	if r.Command != nil {
+		job.Command = *r.Command
+	}
+	if r.TimeoutSeconds != nil {
+		job.TimeoutSeconds = *r.TimeoutSeconds
+	}
+	if r.OverlapPolicy != nil {
+		job.OverlapPolicy = *r.OverlapPolicy
+	}
+	if r.Enabled != nil {
+		job.Enabled = *r.Enabled
+	}
+
+	if job.OverlapPolicy == "" {
+		job.OverlapPolicy = models.CronOverlapForbid
+	}
+	if job.TimeoutSeconds <= 0 {
+		job.TimeoutSeconds = int(defaultCronTimeout.Seconds())
+	}
+	if job.Command == "" {
+		return fmt.Errorf("command is required")
+	}
+	if !job.OverlapPolicy.Valid() {
+		return fmt.Errorf("overlap_policy must be one of forbid, allow, replace")
+	}
+	if _, err := cron.ParseStandard(job.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule: %v", err)
+	}
+	return nil
+}
+
+func registerCronRoutes(db *gorm.DB, sched *cronScheduler, v1 *gin.RouterGroup, projectAccess gin.HandlerFunc) {
+	deployer := requireRole(models.RoleDeployer)
+	// A job's command runs in the project's container like a terminal
+	// session, so writing one takes the terminal role.
+	terminal := requireRole(terminalRole())
+	readScope := requireScope(models.ScopeDeployRead)
+	deployScope := requireScope(models.ScopeDeployWrite)
+
+	findJob := func(c *gin.Context) (models.CronJob, bool) {
+		var job models.CronJob
+		if err := db.Where("project_id = ?", c.Param("id")).First(&job, c.Param("jobId")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Cron job not found"})
+			return job, false
+		}
+		job.NextRunAt = sched.nextRun(job.ID)
+		return job, true
+	}
+
+	v1.GET("/projects/:id/cronjobs", readScope, projectAccess, func(c *gin.Context) {
+		var jobs []models.CronJob
+		db.Where("project_id = ?", c.Param("id")).Order("id").Find(&jobs)
+		for i := range jobs {
+			jobs[i].NextRunAt = sched.nextRun(jobs[i].ID)
+		}
+		c.JSON(200, jobs)
+	})
+
+	v1.POST("/projects/:id/cronjobs", deployer, terminal, deployScope, projectAccess, func(c *gin.Context) {
+		var req cronJobRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		var project models.Project
+		if err := db.First(&project, c.Param("id")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+
+		job := models.CronJob{ProjectID: project.ID, Enabled: true}
+		if err := req.apply(&job); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		db.Create(&job)
+		sched.sync(job)
+		job.NextRunAt = sched.nextRun(job.ID)
+		auditAfter(c, job)
+		c.JSON(201, job)
+	})
+
+	v1.PUT("/projects/:id/cronjobs/:jobId", deployer, terminal, deployScope, projectAccess, func(c *gin.Context) {
+		job, ok := findJob(c)
+		if !ok {
+			return
+		}
+		var req cronJobRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		auditBefore(c, job)
+		if err := req.apply(&job); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		db.Save(&job)
+		sched.sync(job)
+		job.NextRunAt = sched.nextRun(job.ID)
+		auditAfter(c, job)
+		c.JSON(200, job)
+	})
+
+	v1.DELETE("/projects/:id/cronjobs/:jobId", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		job, ok := findJob(c)
+		if !ok {
+			return
+		}
+		auditBefore(c, job)
+		sched.remove(job.ID)
+		db.Where("cron_job_id = ?", job.ID).Delete(&models.CronRun{})
+		db.Delete(&job)
+		c.Status(204)
+	})
+
+	// Runs the job now, outside its schedule. The overlap policy still applies.
+	v1.POST("/projects/:id/cronjobs/:jobId/run", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		job, ok := findJob(c)
+		if !ok {
+			return
+		}
+		run, err := sched.trigger(job.ID, "manual")
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(202, run)
+	})
+
+	// Run history, newest first, without logs.
+	v1.GET("/projects/:id/cronjobs/:jobId/runs", readScope, projectAccess, func(c *gin.Context) {
+		job, ok := findJob(c)
+		if !ok {
+			return
+		}
+		var runs []models.CronRun
+		db.Omit("logs").Where("cron_job_id = ?", job.ID).Order("id DESC").Find(&runs)
+		c.JSON(200, runs)
+	})
+
+	v1.GET("/projects/:id/cronjobs/:jobId/runs/:runId", readScope, projectAccess, func(c *gin.Context) {
+		job, ok := findJob(c)
+		if !ok {
+			return
+		}
+		var run models.CronRun
+		if err := db.Where("cron_job_id = ?", job.ID).First(&run, c.Param("runId")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Run not found"})
+			return
+		}
+		c.JSON(200, run)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// backupEncryption holds the age recipient new archives are encrypted to and
+// the matching identity used to read them back when verifying. Archives are
+// regular age files, so they can be restored with the age CLI as well.
+type backupEncryption struct {
+	recipient age.Recipient
+	identity  age.Identity
+}
+
+// loadBackupEncryption reads BACKUP_ENCRYPTION_KEY (an AGE-SECRET-KEY-1...
+// identity, see age-keygen) or BACKUP_ENCRYPTION_PASSPHRASE. It returns nil
+// when neither is set and backups are stored unencrypted.
+func loadBackupEncryption() (*backupEncryption, error) {
+	if key := os.Getenv("BACKUP_ENCRYPTION_KEY"); key != "" {
+		identity, err := age.ParseX25519Identity(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BACKUP_ENCRYPTION_KEY: %v", err)
+		}
+		return &backupEncryption{recipient: identity.Recipient(), identity: identity}, nil
+	}
+
+	if pass := os.Getenv("BACKUP_ENCRYPTION_PASSPHRASE"); pass != "" {
+		recipient, err := age.NewScryptRecipient(pass)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.NewScryptIdentity(pass)
+		if err != nil {
+			return nil, err
+		}
+		return &backupEncryption{recipient: recipient, identity: identity}, nil
+	}
+
+	return nil, nil
+}
+
+func (e *backupEncryption) encrypt(w io.Writer) (io.WriteCloser, error) {
+	return age.Encrypt(w, e.recipient)
+}
+
+func (e *backupEncryption) decrypt(r io.Reader) (io.Reader, error) {
+	return age.Decrypt(r, e.identity)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+// maxUploadBytes caps a single file upload.
+const maxUploadBytes = 1 << 30
+
+type fileEntry struct {
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	Type     string     `json:"type"` // "dir", "file", "symlink" or "volume"
+	Size     int64      `json:"size"`
+	ModTime  *time.Time `json:"mod_time,omitempty"`
+	VolumeID uint       `json:"volume_id"`
+}
+
+// volumeRootPath returns the host directory holding a volume's files. Named
+// volumes are only reachable when the API runs on the Docker host itself.
+func volumeRootPath(ctx context.Context, orch *orchestrator.DockerOrchestrator, v models.Volume) (string, error) {
+	if err := checkVolume(&v); err != nil {
+		return "", err
+	}
+	if v.Type != models.VolumeTypeNamed {
+		return v.HostPath, nil
+	}
+
+	mountpoint, err := orch.VolumeMountpoint(ctx, v.Name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(mountpoint); err != nil {
+		return "", fmt.Errorf("volume %s is not accessible from the API; run it on the Docker host", v.Name)
+	}
+	return mountpoint, nil
+}
+
+// openVolumeRoot loads the ?volume= volume of the :id project and opens its
+// root. Every file operation goes through the returned os.Root, which refuses
+// paths (including symlinks) that lead outside the volume. It also returns the
+// cleaned ?path= relative to the root, "." for the root itself.
+func openVolumeRoot(c *gin.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator) (*os.Root, models.Volume, string, bool) {
+	var volume models.Volume
+	if err := db.Where("project_id = ?", c.Param("id")).First(&volume, c.Query("volume")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Volume not found"})
+		return nil, volume, "", false
+	}
+
+	rootPath, err := volumeRootPath(c.Request.Context(), orch, volume)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return nil, volume, "", false
+	}
+	if c.Request.Method == http.MethodPut {
+		// Docker creates missing bind directories on start; uploads do too.
+		if err := os.MkdirAll(rootPath, 0755); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return nil, volume, "", false
+		}
+	}
+	root, err := os.OpenRoot(rootPath)
+	if err != nil {
+		fileError(c, err)
+		return nil, volume, "", false
+	}
+
+	rel := strings.TrimPrefix(path.Clean("/"+c.Query("path")), "/")
+	if rel == "" {
+		rel = "."
+	}
+	return root, volume, rel, true
+}
+
+func modTime(info fs.FileInfo) *time.Time {
+	t := info.ModTime()
+	return &t
+}
+
+func entryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "file"
+	}
+}
+
+// fileError maps filesystem errors to responses. Escapes out of the root are
+// reported like missing files.
+func fileError(c *gin.Context, err error) {
+	if os.IsNotExist(err) || strings.Contains(err.Error(), "path escapes") {
+		c.JSON(404, gin.H{"error": "File not found"})
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
+
+func registerFileRoutes(db *gorm.DB, orch *orchestrator.DockerOrchestrator, v1 *gin.RouterGroup, projectAccess gin.HandlerFunc) {
+	deployer := requireRole(models.RoleDeployer)
+	readScope := requireScope(models.ScopeDeployRead)
+	deployScope := requireScope(models.ScopeDeployWrite)
+
+	// Without ?volume= this lists the project's volumes as the top level.
+	v1.GET("/projects/:id/files", readScope, projectAccess, func(c *gin.Context) {
+		if c.Query("volume") == "" {
+			var volumes []models.Volume
+			db.Where("project_id = ?", c.Param("id")).Order("id").Find(&volumes)
+			entries := []fileEntry{}
+			for _, v := range volumes {
+				entries = append(entries, fileEntry{Name: v.ContainerPath, Path: "/", Type: "volume", VolumeID: v.ID})
+			}
+			c.JSON(200, entries)
+			return
+		}
+
+		root, volume, rel, ok := openVolumeRoot(c, db, orch)
+		if !ok {
+			return
+		}
+		defer root.Close()
+
+		dirEntries, err := fs.ReadDir(root.FS(), rel)
+		if err != nil {
+			fileError(c, err)
+			return
+		}
+		entries := []fileEntry{}
+		for _, d := range dirEntries {
+			info, err := d.Info()
+			if err != nil {
+				continue
+			}
+			entries = append(entries, fileEntry{
+				Name:     d.Name(),
+				Path:     "/" + path.Join(rel, d.Name()),
+				Type:     entryType(info.Mode()),
+				Size:     info.Size(),
+				ModTime:  modTime(info),
+				VolumeID: volume.ID,
+			})
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			if (entries[i].Type == "dir") != (entries[j].Type == "dir") {
+				return entries[i].Type == "dir"
+			}
+			return entries[i].Name < entries[j].Name
+		})
+		c.JSON(200, entries)
+	})
+
+	// Files are sent as is, directories as a tar archive.
+	v1.GET("/projects/:id/files/download", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		root, _, rel, ok := openVolumeRoot(c, db, orch)
+		if !ok {
+			return
+		}
+		defer root.Close()
+
+		info, err := root.Stat(rel)
+		if err != nil {
+			fileError(c, err)
+			return
+		}
+
+		name := path.Base("/" + rel)
+		if rel == "." {
+			name = "volume"
+		}
+
+		if !info.IsDir() {
+			f, err := root.Open(rel)
+			if err != nil {
+				fileError(c, err)
+				return
+			}
+			defer f.Close()
+			c.DataFromReader(200, info.Size(), "application/octet-stream", f, map[string]string{
+				"Content-Disposition": fmt.Sprintf("attachment; filename=%q", name),
+			})
+			return
+		}
+
+		c.Header("Content-Type", "application/x-tar")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".tar"))
+		c.Status(200)
+		if err := writeRootTar(c.Writer, root, rel); err != nil {
+			// Headers are gone already; a truncated archive is all we can signal.
+			fmt.Printf("Failed to archive %s: %v\n", rel, err)
+		}
+	})
+
+	// The request body becomes the file at ?path=, replacing any existing one.
+	v1.PUT("/projects/:id/files", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		root, volume, rel, ok := openVolumeRoot(c, db, orch)
+		if !ok {
+			return
+		}
+		defer root.Close()
+
+		if rel == "." {
+			c.JSON(400, gin.H{"error": "path must name a file"})
+			return
+		}
+		if err := root.MkdirAll(path.Dir(rel), 0755); err != nil {
+			fileError(c, err)
+			return
+		}
+
+		// Write next to the target and rename, so readers never see half a file.
+		tmp := path.Join(path.Dir(rel), fmt.Sprintf(".%s.upload-%d", path.Base(rel), time.Now().UnixNano()))
+		f, err := root.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			fileError(c, err)
+			return
+		}
+		size, err := io.Copy(f, http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = root.Rename(tmp, rel)
+		}
+		if err != nil {
+			root.Remove(tmp)
+			c.JSON(400, gin.H{"error": "Upload failed: " + err.Error()})
+			return
+		}
+
+		info, err := root.Stat(rel)
+		if err != nil {
+			fileError(c, err)
+			return
+		}
+		auditAfter(c, gin.H{"volume_id": volume.ID, "path": "/" + rel, "size": size})
+		c.JSON(201, fileEntry{
+			Name:     path.Base(rel),
+			Path:     "/" + rel,
+			Type:     entryType(info.Mode()),
+			Size:     info.Size(),
+			ModTime:  modTime(info),
+			VolumeID: volume.ID,
+		})
+	})
+
+	v1.DELETE("/projects/:id/files", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		root, volume, rel, ok := openVolumeRoot(c, db, orch)
+		if !ok {
+			return
+		}
+		defer root.Close()
+
+		if rel == "." {
+			c.JSON(400, gin.H{"error": "Refusing to delete the volume root"})
+			return
+		}
+		if _, err := root.Lstat(rel); err != nil {
+			fileError(c, err)
+			return
+		}
+		auditBefore(c, gin.H{"volume_id": volume.ID, "path": "/" + rel})
+		if err := root.RemoveAll(rel); err != nil {
+			fileError(c, err)
+			return
+		}
+		c.Status(204)
+	})
+}
+
+// writeRootTar archives the directory rel of root, with entry names relative
+// to it. Symlinks are stored as links and never followed.
+func writeRootTar(w io.Writer, root *os.Root, rel string) error {
+	tw := tar.NewWriter(w)
+	err := fs.WalkDir(root.FS(), rel, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == rel {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = root.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = strings.TrimPrefix(p, rel+"/")
+		if rel == "." {
+			hdr.Name = p
+		}
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := root.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
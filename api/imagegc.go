@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+const (
+	// imageProjectLabel marks the images Orchestro builds, so untagged ones
+	// can be told apart from everything else on the host.
+	imageProjectLabel = "orchestro.project"
+
+	defaultImageGCKeep     = 3
+	defaultImageGCSchedule = "@daily"
+	defaultBuildCacheMaxGB = 10
+	imageGCTimeout         = 30 * time.Minute
+)
+
+// projectImageTag matches the tags of images built for projects,
+// orchestro-p<project>:latest and orchestro-p<project>:d<deployment>.
+var projectImageTag = regexp.MustCompile(`^orchestro-p[0-9]+:`)
+
+var imageGC = struct {
+	keep          int
+	schedule      string
+	buildCacheMax int64
+
+	mu      sync.Mutex
+	running bool
+	last    *imageGCReport
+}{keep: defaultImageGCKeep, schedule: defaultImageGCSchedule, buildCacheMax: defaultBuildCacheMaxGB << 30}
+
+// imageGCReport is what one garbage collection did.
+type imageGCReport struct {
+	Trigger             string    `json:"trigger"` // "schedule" or "manual"
+	StartedAt           time.Time `json:"started_at"`
+	FinishedAt          time.Time `json:"finished_at"`
+	ImagesRemoved       int       `json:"images_removed"`
+	ImagesKept          int       `json:"images_kept"`
+	ImageBytesReclaimed int64     `json:"image_bytes_reclaimed"`
+	BuildCacheReclaimed int64     `json:"build_cache_bytes_reclaimed"`
+	TotalBytesReclaimed int64     `json:"total_bytes_reclaimed"`
+	Errors              []string  `json:"errors,omitempty"`
+}
+
+// loadImageGC reads IMAGE_GC_KEEP, IMAGE_GC_SCHEDULE and BUILD_CACHE_MAX_GB.
+func loadImageGC() error {
+	if value := os.Getenv("IMAGE_GC_KEEP"); value != "" {
+		keep, err := strconv.Atoi(value)
+		if err != nil || keep < 1 {
+			return fmt.Errorf("IMAGE_GC_KEEP must be at least 1, got %q", value)
+		}
+		imageGC.keep = keep
+	}
+	if value := os.Getenv("IMAGE_GC_SCHEDULE"); value != "" {
+		if value != "off" {
+			if _, err := cron.ParseStandard(value); err != nil {
+				return fmt.Errorf("IMAGE_GC_SCHEDULE: %v", err)
+			}
+		}
+		imageGC.schedule = value
+	}
+	if value := os.Getenv("BUILD_CACHE_MAX_GB"); value != "" {
+		gb, err := strconv.Atoi(value)
+		if err != nil || gb < 0 {
+			return fmt.Errorf("BUILD_CACHE_MAX_GB must be a number of GB, got %q", value)
+		}
+		imageGC.buildCacheMax = int64(gb) << 30
+	}
+	return nil
+}
+
+// startImageGC runs the garbage collection on IMAGE_GC_SCHEDULE.
+func startImageGC(db *gorm.DB, orch *orchestrator.DockerOrchestrator) {
+	if imageGC.schedule == "off" {
+		return
+	}
+	c := cron.New()
+	c.AddFunc(imageGC.schedule, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), imageGCTimeout)
+		defer cancel()
+		runImageGC(ctx, db, orch, "schedule")
+	})
+	c.Start()
+}
+
+// recordDeploymentImage stores the ID of the image a deployment was built
+// from and tags it for the deployment, so it stays around for a rollback
+// after later builds take over the project's tag. It returns the reference
+// the deployment should run: that tag, or the image ID when tagging fails.
+func recordDeploymentImage(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, deployment *models.Deployment, imageName string) string {
+	id, err := orch.ImageID(ctx, imageName)
+	if err != nil {
+		fmt.Printf("Failed to inspect image %s: %v\n", imageName, err)
+		return imageName
+	}
+	deployment.ImageID = id
+	db.Save(deployment)
+
+	tag := fmt.Sprintf("%s:d%d", imageName, deployment.ID)
+	if err := orch.TagImage(ctx, imageName, tag); err != nil {
+		fmt.Printf("Failed to tag image %s for deployment %d: %v\n", imageName, deployment.ID, err)
+		return id
+	}
+	return tag
+}
+
+// imagesToKeep is the images of each project's last imageGC.keep deployments
+// and, by tag, the image each project builds into, which may not belong to a
+// deployment yet.
+func imagesToKeep(db *gorm.DB) (map[string]bool, map[string]bool) {
+	ids := make(map[string]bool)
+	tags := make(map[string]bool)
+
+	var projects []models.Project
+	db.Select("id").Find(&projects)
+	for _, project := range projects {
+		tags[fmt.Sprintf("orchestro-p%d:latest", project.ID)] = true
+
+		var imageIDs []string
+		db.Model(&models.Deployment{}).
+			Where("project_id = ? AND image_id != ''", project.ID).
+			Order("id DESC").
+			Pluck("image_id", &imageIDs)
+		kept := 0
+		for _, id := range imageIDs {
+			if kept == imageGC.keep {
+				break
+			}
+			if !ids[id] {
+				ids[id] = true
+				kept++
+			}
+		}
+	}
+	return ids, tags
+}
+
+// runImageGC removes Orchestro's images that are no longer in any project's
+// rollback window, untagged images left behind by its builds, and build
+// cache beyond BUILD_CACHE_MAX_GB. Images a container still uses stay.
+func runImageGC(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, trigger string) (*imageGCReport, error) {
+	imageGC.mu.Lock()
+	if imageGC.running {
+		imageGC.mu.Unlock()
+		return nil, fmt.Errorf("image garbage collection is already running")
+	}
+	imageGC.running = true
+	imageGC.mu.Unlock()
+
+	report := &imageGCReport{Trigger: trigger, StartedAt: time.Now()}
+	defer func() {
+		report.FinishedAt = time.Now()
+		report.TotalBytesReclaimed = report.ImageBytesReclaimed + report.BuildCacheReclaimed
+		fmt.Printf("Image GC: removed %d images, kept %d, reclaimed %.1f MB\n",
+			report.ImagesRemoved, report.ImagesKept, float64(report.TotalBytesReclaimed)/1024/1024)
+
+		imageGC.mu.Lock()
+		imageGC.running = false
+		imageGC.last = report
+		imageGC.mu.Unlock()
+	}()
+
+	before, err := orch.ImageDiskUsage(ctx)
+	if err != nil {
+		report.Errors = append(report.Errors, "disk usage: "+err.Error())
+	}
+
+	keepIDs, keepTags := imagesToKeep(db)
+	images, err := orch.ListImages(ctx, "orchestro-p", imageProjectLabel)
+	if err != nil {
+		report.Errors = append(report.Errors, "list images: "+err.Error())
+	}
+	for _, img := range images {
+		var tags []string
+		for _, tag := range img.Tags {
+			if projectImageTag.MatchString(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) == 0 && len(img.Tags) > 0 {
+			continue
+		}
+		keep := keepIDs[img.ID]
+		for _, tag := range tags {
+			keep = keep || keepTags[tag]
+		}
+		if keep {
+			report.ImagesKept++
+			continue
+		}
+
+		// Removing the tags deletes the image with the last of them, but
+		// only untags images that also carry a registry tag of their own.
+		refs := tags
+		if len(refs) == 0 {
+			refs = []string{img.ID}
+		}
+		removed := true
+		for _, ref := range refs {
+			ok, err := orch.RemoveImage(ctx, ref)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("remove %s: %v", ref, err))
+			}
+			removed = removed && ok
+		}
+		if removed {
+			report.ImagesRemoved++
+		} else {
+			report.ImagesKept++
+		}
+	}
+
+	if before > 0 {
+		if after, err := orch.ImageDiskUsage(ctx); err == nil && after < before {
+			report.ImageBytesReclaimed = before - after
+		}
+	}
+
+	reclaimed, err := orch.PruneBuildCache(ctx, imageGC.buildCacheMax)
+	report.BuildCacheReclaimed = reclaimed
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	return report, nil
+}
+
+func registerImageRoutes(db *gorm.DB, orch *orchestrator.DockerOrchestrator, v1 *gin.RouterGroup) {
+	admin := requireRole(models.RoleAdmin)
+	adminScope := requireScope(models.ScopeAdmin)
+
+	// The garbage collection settings and the report of its last run.
+	v1.GET("/images/gc", admin, adminScope, func(c *gin.Context) {
+		imageGC.mu.Lock()
+		last, running := imageGC.last, imageGC.running
+		imageGC.mu.Unlock()
+		c.JSON(200, gin.H{
+			"schedule":           imageGC.schedule,
+			"keep_deployments":   imageGC.keep,
+			"build_cache_max_gb": imageGC.buildCacheMax >> 30,
+			"running":            running,
+			"last":               last,
+		})
+	})
+
+	// Runs a garbage collection now and returns its report.
+	v1.POST("/images/gc", admin, adminScope, func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), imageGCTimeout)
+		defer cancel()
+		report, err := runImageGC(ctx, db, orch, "manual")
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		auditAfter(c, gin.H{"images_removed": report.ImagesRemoved, "reclaimed": report.TotalBytesReclaimed})
+		c.JSON(200, report)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/reference"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+// checkProjectSource validates where a project's image comes from.
+func checkProjectSource(project *models.Project) error {
+	if !project.Type.Valid() {
+		return fmt.Errorf("type must be git or image")
+	}
+	if project.Type != models.ProjectTypeImage {
+		return nil
+	}
+	if project.Image == "" {
+		return fmt.Errorf("image is required for image projects")
+	}
+	if _, err := reference.ParseNormalizedNamed(project.Image); err != nil {
+		return fmt.Errorf("invalid image reference %q: %v", project.Image, err)
+	}
+	return nil
+}
+
+// imageTag is the tag an image project follows, "" when it is pinned to a
+// digest.
+func imageTag(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	if _, ok := named.(reference.Canonical); ok {
+		return ""
+	}
+	if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+		return tagged.Tag()
+	}
+	return ""
+}
+
+// imageWebhookPayload covers the registries' push notifications we know:
+// Docker Hub sends push_data.tag, GitHub's package events carry the tag deep
+// in package_version, and anything else can send {"tag": "..."}.
+type imageWebhookPayload struct {
+	Tag      string `json:"tag"`
+	PushData struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Package struct {
+		PackageVersion struct {
+			ContainerMetadata struct {
+				Tag struct {
+					Name string `json:"name"`
+				} `json:"tag"`
+			} `json:"container_metadata"`
+		} `json:"package_version"`
+	} `json:"package"`
+}
+
+func (p imageWebhookPayload) tag() string {
+	switch {
+	case p.Tag != "":
+		return p.Tag
+	case p.PushData.Tag != "":
+		return p.PushData.Tag
+	default:
+		return p.Package.PackageVersion.ContainerMetadata.Tag.Name
+	}
+}
+
+// pullProjectImage pulls an image project's image into imageName, where a
+// build would have put it, and records the digest on the deployment. On
+// failure the deployment is marked failed and false returned.
+func pullProjectImage(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, hub *Hub, project models.Project, deployment *models.Deployment, imageName string) bool {
+	hub.BroadcastLogs(project.ID, fmt.Sprintf("Pulling %s...\n", project.Image))
+
+	var logs []byte
+	digest, err := orch.PullImage(ctx, project.Image, imageName, project.RegistryUsername, project.RegistryPassword, func(line string) {
+		logs = append(logs, line...)
+		hub.BroadcastLogs(project.ID, line)
+	})
+	deployment.Logs = string(logs)
+	if err != nil {
+		updateDeploymentStatus(db, deployment, models.StatusFailed, deployment.Logs+"\nPull failed: "+err.Error())
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return false
+	}
+
+	deployment.ImageDigest = digest
+	deployment.Logs += fmt.Sprintf("Pulled %s\n", digest)
+	hub.BroadcastLogs(project.ID, fmt.Sprintf("Pulled %s\n", digest))
+	db.Save(deployment)
+	return true
+}
@@ -2,26 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/glebarez/sqlite"
 	"github.com/timuzkas/orchestro/api/models"
 	"github.com/timuzkas/orchestro/api/orchestrator"
+	"github.com/timuzkas/orchestro/api/storage"
 	"gorm.io/gorm"
-)
-
-var (
-	deploymentCancels = make(map[uint]context.CancelFunc)
-	cancelMutex       sync.Mutex
+	"gorm.io/gorm/clause"
 )
 
 func main() {
@@ -30,20 +30,55 @@ func main() {
 		log.Fatalf("failed to connect database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.Project{}, &models.EnvVar{}, &models.Deployment{}, &models.Backup{}, &models.Volume{})
+	err = db.AutoMigrate(&models.Project{}, &models.EnvVar{}, &models.Deployment{}, &models.Backup{}, &models.Volume{}, &models.User{}, &models.Session{}, &models.APIToken{}, &models.AuditEvent{}, &models.CronJob{}, &models.CronRun{}, &models.PortAllocation{}, &models.Addon{})
 	if err != nil {
 		log.Fatalf("failed to migrate database: %v", err)
 	}
+	db.Model(&models.Backup{}).Where("scope IS NULL OR scope = ''").Update("scope", models.BackupScopeProject)
+	db.Model(&models.Backup{}).Where("target IS NULL OR target = ''").Updates(map[string]interface{}{
+		"target":    "local",
+		"file_path": gorm.Expr("REPLACE(file_path, 'data/backups/', '')"),
+	})
 
 	orch, err := orchestrator.NewDockerOrchestrator()
 	if err != nil {
 		log.Fatalf("failed to initialize orchestrator: %v", err)
 	}
 
+	backupStores, err := storage.NewRegistryFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure backup storage: %v", err)
+	}
+	backupEnc, err := loadBackupEncryption()
+	if err != nil {
+		log.Fatalf("failed to configure backup encryption: %v", err)
+	}
+	volumePolicy, err = loadHostPathPolicy()
+	if err != nil {
+		log.Fatalf("failed to configure volume paths: %v", err)
+	}
+	hostPorts, err = loadPortRange()
+	if err != nil {
+		log.Fatalf("failed to configure ports: %v", err)
+	}
+	backfillPortAllocations(db)
+	if err := loadBuildLimits(); err != nil {
+		log.Fatalf("failed to configure builds: %v", err)
+	}
+	if err := loadImageGC(); err != nil {
+		log.Fatalf("failed to configure image GC: %v", err)
+	}
+
 	hub := newHub()
 	go hub.run()
 
-	r := gin.Default()
+	scheduler := newCronScheduler(db, orch)
+	scheduler.start()
+	restoreReplicaPools(db, orch)
+	startImageGC(db, orch)
+
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(requestLogFormatter), gin.Recovery())
 
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -64,6 +99,7 @@ func main() {
 
 	// --- PUBLIC ENDPOINTS ---
 	public := r.Group("/api/v1")
+	public.Use(auditMiddleware(db))
 	{
 		public.GET("/webhooks/:id/:provider", func(c *gin.Context) {
 			c.String(200, "Orchestro Webhook Endpoint is active. Please use POST requests for triggers.")
@@ -72,10 +108,11 @@ func main() {
 		public.POST("/webhooks/:id/:provider", func(c *gin.Context) {
 			id := c.Param("id")
 			provider := c.Param("provider")
+			c.Set("audit_actor", "webhook:"+provider)
 			fmt.Printf("Webhook received: ID=%s, Provider=%s\n", id, provider)
 
 			var project models.Project
-			if err := db.Preload("EnvVars").Preload("Volumes").First(&project, id).Error; err != nil {
+			if err := db.Preload("EnvVars").Preload("Volumes").Preload("Addons").First(&project, id).Error; err != nil {
 				fmt.Printf("Webhook error: Project %s not found\n", id)
 				c.JSON(404, gin.H{"error": "Project not found"})
 				return
@@ -95,12 +132,37 @@ func main() {
 				} else {
 					fmt.Printf("Webhook error: failed to bind JSON: %v\n", err)
 				}
+			} else if provider == "image" && project.Type == models.ProjectTypeImage {
+				// Registries can't sign their calls, so the secret comes as a
+				// header or query parameter when the project has one.
+				if project.WebhookSecret != "" {
+					secret := c.GetHeader("X-Webhook-Secret")
+					if secret == "" {
+						secret = c.Query("secret")
+					}
+					if subtle.ConstantTimeCompare([]byte(secret), []byte(project.WebhookSecret)) != 1 {
+						c.JSON(401, gin.H{"error": "Invalid webhook secret"})
+						return
+					}
+				}
+				var payload imageWebhookPayload
+				c.ShouldBindJSON(&payload)
+				// Digest-pinned images never change, so there is nothing to redeploy.
+				tag := imageTag(project.Image)
+				fmt.Printf("Webhook payload: tag=%s, target_tag=%s\n", payload.tag(), tag)
+				if tag != "" && (payload.tag() == "" || payload.tag() == tag) {
+					trigger = true
+				}
 			}
 
 			if trigger {
 				fmt.Printf("Webhook success: triggering deployment for project %d\n", project.ID)
 				c.JSON(202, gin.H{"message": "Deployment triggered"})
-				go handleDeploy(context.Background(), db, orch, hub, project)
+				ctx, done := startDeploy(project.ID)
+				go func() {
+					defer done()
+					handleDeploy(ctx, db, orch, hub, project, nil)
+				}()
 			} else {
 				fmt.Printf("Webhook skipped: no action for project %d\n", project.ID)
 				c.JSON(200, gin.H{"message": "No action taken"})
@@ -109,24 +171,42 @@ func main() {
 	}
 
 	// --- AUTHORIZED ENDPOINTS ---
+	bootstrapAdmin(db)
 	authorized := r.Group("/")
-	apiUser := os.Getenv("API_USER")
-	apiPass := os.Getenv("API_PASS")
-	if apiUser != "" && apiPass != "" {
-		authorized.Use(gin.BasicAuth(gin.Accounts{
-			apiUser: apiPass,
-		}))
-	}
+	authorized.Use(authMiddleware(db), auditMiddleware(db))
+
+	deployer := requireRole(models.RoleDeployer)
+	admin := requireRole(models.RoleAdmin)
+	projectAccess := requireProjectAccess(db)
+	backupAccess := requireBackupAccess(db)
 
-	authorized.GET("/ws", func(c *gin.Context) {
-		serveWs(hub, c.Writer, c.Request)
+	readScope := requireScope(models.ScopeDeployRead)
+	deployScope := requireScope(models.ScopeDeployWrite)
+	envScope := requireScope(models.ScopeEnvWrite)
+	backupScope := requireScope(models.ScopeBackups)
+	adminScope := requireScope(models.ScopeAdmin)
+
+	authorized.GET("/ws", readScope, func(c *gin.Context) {
+		serveWs(hub, projectFilter(db, c), c.Writer, c.Request)
 	})
 
 	v1 := authorized.Group("/api/v1")
+	registerAuthRoutes(db, public, v1)
+	registerTokenRoutes(db, v1)
+	registerAuditRoutes(db, v1)
+	registerFileRoutes(db, orch, v1, projectAccess)
+	registerTerminalRoutes(db, orch, v1, projectAccess)
+	registerRunRoutes(db, orch, v1, projectAccess)
+	registerCronRoutes(db, scheduler, v1, projectAccess)
+	registerNetworkRoutes(db, v1)
+	registerAddonRoutes(db, orch, v1, projectAccess)
+	registerUploadRoutes(db, orch, hub, v1, projectAccess)
+	registerBuildRoutes(db, v1, projectAccess)
+	registerImageRoutes(db, orch, v1)
 	{
-		v1.GET("/projects", func(c *gin.Context) {
+		v1.GET("/projects", readScope, func(c *gin.Context) {
 			var projects []models.Project
-			db.Preload("Deployments", func(db *gorm.DB) *gorm.DB {
+			visibleProjects(db, c).Preload("Deployments", func(db *gorm.DB) *gorm.DB {
 				return db.Order("id DESC")
 			}).Find(&projects)
 
@@ -135,8 +215,13 @@ func main() {
 				LiveState string `json:"live_state"`
 			}
 
+			canSeeSecrets := currentUser(c).Role.Allows(models.RoleDeployer)
 			var results []projectWithLive
 			for _, p := range projects {
+				if !canSeeSecrets {
+					p.RegistryPassword = ""
+					p.WebhookSecret = ""
+				}
 				state := "stopped"
 				if len(p.Deployments) > 0 && p.Deployments[0].ContainerID != "" {
 					s, _ := orch.GetContainerStatus(context.Background(), p.Deployments[0].ContainerID)
@@ -150,16 +235,27 @@ func main() {
 			c.JSON(200, results)
 		})
 
-		v1.GET("/projects/:id", func(c *gin.Context) {
+		v1.GET("/projects/:id", readScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var project models.Project
 			if err := db.Preload("Deployments", func(db *gorm.DB) *gorm.DB {
 				return db.Order("id DESC")
-			}).Preload("EnvVars").Preload("Backups").Preload("Volumes").First(&project, id).Error; err != nil {
+			}).Preload("EnvVars").Preload("Backups").Preload("Volumes").Preload("Addons").First(&project, id).Error; err != nil {
 				c.JSON(404, gin.H{"error": "Project not found"})
 				return
 			}
 
+			if !currentUser(c).Role.Allows(models.RoleDeployer) {
+				// Viewers can see which variables exist but not their values.
+				for i := range project.EnvVars {
+					project.EnvVars[i].Value = ""
+				}
+				project.RegistryPassword = ""
+				project.WebhookSecret = ""
+			}
+			fillVolumeSizes(c.Request.Context(), orch, project.Volumes)
+			showAddons(c, project.Addons)
+
 			liveInfo := gin.H{"state": "stopped", "memory": 0}
 			if len(project.Deployments) > 0 && project.Deployments[0].ContainerID != "" {
 				containerID := project.Deployments[0].ContainerID
@@ -175,11 +271,7 @@ func main() {
 			})
 		})
 
-		v1.GET("/projects/:id/files", func(c *gin.Context) {
-			c.JSON(200, []string{})
-		})
-
-		v1.GET("/projects/:id/logs/runtime", func(c *gin.Context) {
+		v1.GET("/projects/:id/logs/runtime", readScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var project models.Project
 			if err := db.Preload("Deployments", func(db *gorm.DB) *gorm.DB {
@@ -223,14 +315,16 @@ func main() {
 			c.String(200, output)
 		})
 
-		v1.GET("/stats", func(c *gin.Context) {
+		v1.GET("/stats", readScope, func(c *gin.Context) {
+			visible := visibleProjects(db, c).Model(&models.Project{}).Select("id")
+
 			var projectCount int64
 			var deploymentCount int64
-			db.Model(&models.Project{}).Count(&projectCount)
-			db.Model(&models.Deployment{}).Count(&deploymentCount)
+			visibleProjects(db, c).Model(&models.Project{}).Count(&projectCount)
+			db.Model(&models.Deployment{}).Where("project_id IN (?)", visible).Count(&deploymentCount)
 
 			var activeContainers int64
-			db.Model(&models.Deployment{}).Where("status = ? AND container_id != ''", models.StatusReady).Count(&activeContainers)
+			db.Model(&models.Deployment{}).Where("project_id IN (?) AND status = ? AND container_id != ''", visible, models.StatusReady).Count(&activeContainers)
 
 			c.JSON(200, gin.H{
 				"total_projects":    projectCount,
@@ -239,56 +333,173 @@ func main() {
 			})
 		})
 
-		v1.POST("/projects", func(c *gin.Context) {
+		v1.POST("/projects", admin, adminScope, func(c *gin.Context) {
+			var req projectRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
 			var project models.Project
-			if err := c.ShouldBindJSON(&project); err != nil {
+			req.apply(&project)
+			if !checkProjectCommands(c, models.Project{}, project) {
+				return
+			}
+			if !project.BackupMode.Valid() {
+				c.JSON(400, gin.H{"error": "backup_mode must be one of live, pause, stop, hook"})
+				return
+			}
+			if err := checkProjectSource(&project); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			if err := checkBuildSettings(&project); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			if project.Replicas == 0 {
+				project.Replicas = 1
+			}
+			if project.Replicas < 1 || project.Replicas > maxReplicas {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("replicas must be between 1 and %d", maxReplicas)})
+				return
+			}
+			if project.CustomPort < 0 || project.CustomPort > 65535 {
+				c.JSON(400, gin.H{"error": "custom_port must be between 1 and 65535"})
+				return
+			}
+			if err := checkNetworks(db, c, &project); err != nil {
 				c.JSON(400, gin.H{"error": err.Error()})
 				return
 			}
-			if err := db.Create(&project).Error; err != nil {
+			if err := checkCustomPort(db, 0, project.CustomPort); err != nil {
+				c.JSON(409, gin.H{"error": err.Error()})
+				return
+			}
+			if err := db.Omit(clause.Associations).Create(&project).Error; err != nil {
 				c.JSON(500, gin.H{"error": err.Error()})
 				return
 			}
+			if project.CustomPort != 0 {
+				if err := reserveCustomPort(db, project.ID, project.CustomPort); err != nil {
+					db.Unscoped().Delete(&project)
+					c.JSON(409, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			c.Set("audit_project_id", project.ID)
+			auditAfter(c, project)
 			c.JSON(201, project)
 		})
 
-		v1.PUT("/projects/:id", func(c *gin.Context) {
+		v1.PUT("/projects/:id", deployer, deployScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var project models.Project
 			if err := db.First(&project, id).Error; err != nil {
 				c.JSON(404, gin.H{"error": "Project not found"})
 				return
 			}
+			auditBefore(c, project)
+			oldNetworks := project.Networks
+			before := project
 
-			if err := c.ShouldBindJSON(&project); err != nil {
+			var req projectRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			req.apply(&project)
+			if !checkProjectCommands(c, before, project) {
+				return
+			}
+			if !project.BackupMode.Valid() {
+				c.JSON(400, gin.H{"error": "backup_mode must be one of live, pause, stop, hook"})
+				return
+			}
+			if err := checkProjectSource(&project); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			if err := checkBuildSettings(&project); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			if project.Replicas == 0 {
+				project.Replicas = 1
+			}
+			if project.Replicas < 1 || project.Replicas > maxReplicas {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("replicas must be between 1 and %d", maxReplicas)})
+				return
+			}
+			if project.CustomPort < 0 || project.CustomPort > 65535 {
+				c.JSON(400, gin.H{"error": "custom_port must be between 1 and 65535"})
+				return
+			}
+			if err := checkNetworks(db, c, &project); err != nil {
 				c.JSON(400, gin.H{"error": err.Error()})
 				return
 			}
 
-			db.Save(&project)
+			if err := reserveCustomPort(db, project.ID, project.CustomPort); err != nil {
+				c.JSON(409, gin.H{"error": err.Error()})
+				return
+			}
+
+			db.Omit(clause.Associations).Save(&project)
+			pruneNetworks(context.Background(), db, orch, oldNetworks)
+			auditAfter(c, project)
 			c.JSON(200, project)
 		})
 
-		v1.DELETE("/projects/:id", func(c *gin.Context) {
+		v1.DELETE("/projects/:id", admin, adminScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var project models.Project
-			if err := db.Preload("Deployments").First(&project, id).Error; err != nil {
+			if err := db.Preload("Deployments").Preload("Backups").Preload("Volumes").Preload("Addons").First(&project, id).Error; err != nil {
 				c.JSON(404, gin.H{"error": "Project not found"})
 				return
 			}
+			auditBefore(c, project)
 
+			replicaBalancer.close(project.ID)
 			for _, d := range project.Deployments {
-				if d.ContainerID != "" {
-					orch.StopContainer(context.Background(), d.ContainerID)
-					orch.RemoveContainer(context.Background(), d.ContainerID)
+				for _, id := range d.ContainerIDs() {
+					orch.StopContainer(context.Background(), id)
+					orch.RemoveContainer(context.Background(), id)
 				}
 			}
 
-			db.Select("Deployments", "EnvVars", "Backups", "Volumes").Unscoped().Delete(&project)
+			for _, b := range project.Backups {
+				if err := deleteBackup(context.Background(), db, backupStores, b); err != nil {
+					fmt.Printf("Failed to delete backup %d of project %d: %v\n", b.ID, project.ID, err)
+				}
+			}
+
+			removeNamedVolumes(context.Background(), orch, project.Volumes)
+			removeAddons(context.Background(), db, orch, project.ID, project.Addons)
+			os.RemoveAll(uploadsDir(project.ID))
+			os.RemoveAll(buildCacheDir(project.ID))
+			scheduler.removeProject(project.ID)
+			releasePorts(db, project.ID)
+
+			// Memberships and project tokens go with the project, or they
+			// would grant access to a new project that reuses its ID.
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec("DELETE FROM project_members WHERE project_id = ?", project.ID).Error; err != nil {
+					return err
+				}
+				if err := tx.Where("project_id = ?", project.ID).Delete(&models.APIToken{}).Error; err != nil {
+					return err
+				}
+				return tx.Select("Deployments", "EnvVars", "Backups", "Volumes").Unscoped().Delete(&project).Error
+			})
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			pruneNetworks(context.Background(), db, orch, project.Networks)
 			c.Status(204)
 		})
 
-		v1.POST("/projects/:id/env", func(c *gin.Context) {
+		v1.POST("/projects/:id/env", deployer, envScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var envVar models.EnvVar
 			if err := c.ShouldBindJSON(&envVar); err != nil {
@@ -302,22 +513,32 @@ func main() {
 			}
 			envVar.ProjectID = project.ID
 			db.Create(&envVar)
+			auditAfter(c, envVar)
 			c.JSON(201, envVar)
 		})
 
-		v1.DELETE("/projects/:id/env/:envId", func(c *gin.Context) {
+		v1.DELETE("/projects/:id/env/:envId", deployer, envScope, projectAccess, func(c *gin.Context) {
 			envId := c.Param("envId")
-			db.Delete(&models.EnvVar{}, envId)
+			var envVar models.EnvVar
+			if db.Where("project_id = ?", c.Param("id")).First(&envVar, envId).Error == nil {
+				auditBefore(c, envVar)
+			}
+			db.Where("project_id = ?", c.Param("id")).Delete(&models.EnvVar{}, envId)
 			c.Status(204)
 		})
 
-		v1.POST("/projects/:id/volumes", func(c *gin.Context) {
+		v1.POST("/projects/:id/volumes", deployer, deployScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var volume models.Volume
 			if err := c.ShouldBindJSON(&volume); err != nil {
 				c.JSON(400, gin.H{"error": err.Error()})
 				return
 			}
+			volume.ID = 0
+			if err := checkVolume(&volume); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
 			var project models.Project
 			if err := db.First(&project, id).Error; err != nil {
 				c.JSON(404, gin.H{"error": "Project not found"})
@@ -325,24 +546,47 @@ func main() {
 			}
 			volume.ProjectID = project.ID
 			db.Create(&volume)
+			if volume.Type == models.VolumeTypeNamed {
+				volume.Name = namedVolumeName(volume)
+				db.Save(&volume)
+				if err := ensureNamedVolumes(c.Request.Context(), orch, []models.Volume{volume}); err != nil {
+					db.Delete(&volume)
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			auditAfter(c, volume)
 			c.JSON(201, volume)
 		})
 
-		v1.DELETE("/projects/:id/volumes/:volumeId", func(c *gin.Context) {
+		v1.DELETE("/projects/:id/volumes/:volumeId", deployer, deployScope, projectAccess, func(c *gin.Context) {
 			volumeId := c.Param("volumeId")
-			db.Delete(&models.Volume{}, volumeId)
+			var volume models.Volume
+			if err := db.Where("project_id = ?", c.Param("id")).First(&volume, volumeId).Error; err != nil {
+				c.JSON(404, gin.H{"error": "Volume not found"})
+				return
+			}
+			auditBefore(c, volume)
+			if volume.Type == models.VolumeTypeNamed {
+				// Docker refuses while a container still uses the volume.
+				if err := orch.RemoveVolume(c.Request.Context(), volume.Name); err != nil {
+					c.JSON(409, gin.H{"error": "Failed to remove volume: " + err.Error()})
+					return
+				}
+			}
+			db.Where("project_id = ?", c.Param("id")).Delete(&models.Volume{}, volumeId)
 			c.Status(204)
 		})
 
-		v1.POST("/projects/:id/backups", func(c *gin.Context) {
+		v1.POST("/projects/:id/backups", deployer, backupScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var project models.Project
-			if err := db.Preload("Volumes").First(&project, id).Error; err != nil {
+			if err := db.First(&project, id).Error; err != nil {
 				c.JSON(404, gin.H{"error": "Project not found"})
 				return
 			}
 
-			backup, err := handleBackup(db, project)
+			backup, err := handleBackup(db, orch, backupStores, backupEnc, project.ID)
 			if err != nil {
 				c.JSON(500, gin.H{"error": err.Error()})
 				return
@@ -350,14 +594,29 @@ func main() {
 			c.JSON(201, backup)
 		})
 
-		v1.GET("/projects/:id/backups", func(c *gin.Context) {
+		v1.GET("/projects/:id/backups", backupScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var backups []models.Backup
-			db.Where("project_id = ?", id).Find(&backups)
+			db.Where("project_id = ? AND scope = ?", id, models.BackupScopeProject).Find(&backups)
+			c.JSON(http.StatusOK, backups)
+		})
+
+		v1.POST("/backups", admin, adminScope, func(c *gin.Context) {
+			backup, err := handleInstanceBackup(db, orch, backupStores, backupEnc)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(201, backup)
+		})
+
+		v1.GET("/backups", admin, adminScope, func(c *gin.Context) {
+			var backups []models.Backup
+			db.Where("scope = ?", models.BackupScopeInstance).Order("id DESC").Find(&backups)
 			c.JSON(http.StatusOK, backups)
 		})
 
-		v1.GET("/backups/:backupId/download", func(c *gin.Context) {
+		v1.GET("/backups/:backupId/download", backupScope, backupAccess, func(c *gin.Context) {
 			backupId := c.Param("backupId")
 			var backup models.Backup
 			if err := db.First(&backup, backupId).Error; err != nil {
@@ -365,30 +624,127 @@ func main() {
 				return
 			}
 
-			c.File(backup.FilePath)
+			headers := map[string]string{
+				"Content-Disposition": fmt.Sprintf("attachment; filename=%q", filepath.Base(backup.FilePath)),
+			}
+			if backup.SHA256 != "" {
+				headers["X-Checksum-Sha256"] = backup.SHA256
+			}
+			if backup.Integrity == models.IntegrityCorrupted {
+				if c.Query("force") != "true" {
+					c.JSON(409, gin.H{"error": "Backup failed its last integrity check. Pass ?force=true to download it anyway."})
+					return
+				}
+				headers["Warning"] = `199 - "backup failed its last integrity check"`
+			}
+
+			store, err := backupStores.Get(backup.Target)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			reader, err := store.Open(c.Request.Context(), backup.FilePath)
+			if err != nil {
+				c.JSON(404, gin.H{"error": "Backup file not found: " + err.Error()})
+				return
+			}
+			defer reader.Close()
+
+			contentType := "application/gzip"
+			if backup.Encrypted {
+				contentType = "application/octet-stream"
+			}
+
+			// The checksum is checked while streaming; a mismatch is too late to
+			// refuse this download but flags the backup so later ones are refused.
+			hashed := newHashingReader(reader)
+			c.DataFromReader(200, backup.Size, contentType, hashed, headers)
+			if backup.SHA256 != "" && hashed.n == backup.Size && hashed.sum() != backup.SHA256 {
+				fmt.Printf("Backup %d failed checksum during download\n", backup.ID)
+				db.Model(&backup).Update("integrity", models.IntegrityCorrupted)
+			}
 		})
 
-		v1.POST("/projects/:id/deploy", func(c *gin.Context) {
+		v1.DELETE("/backups/:backupId", deployer, backupScope, backupAccess, func(c *gin.Context) {
+			backupId := c.Param("backupId")
+			var backup models.Backup
+			if err := db.First(&backup, backupId).Error; err != nil {
+				c.JSON(404, gin.H{"error": "Backup not found"})
+				return
+			}
+			auditBefore(c, backup)
+
+			if err := deleteBackup(c.Request.Context(), db, backupStores, backup); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			c.Status(204)
+		})
+
+		v1.DELETE("/backups", admin, adminScope, func(c *gin.Context) {
+			days, err := strconv.Atoi(c.Query("older_than_days"))
+			if err != nil || days < 0 {
+				c.JSON(400, gin.H{"error": "older_than_days must be a non-negative number of days"})
+				return
+			}
+
+			query := db.Where("created_at < ?", time.Now().AddDate(0, 0, -days))
+			if projectID := c.Query("project_id"); projectID != "" {
+				query = query.Where("project_id = ?", projectID)
+			}
+			var backups []models.Backup
+			query.Find(&backups)
+
+			deleted := 0
+			var failed []gin.H
+			for _, b := range backups {
+				if err := deleteBackup(c.Request.Context(), db, backupStores, b); err != nil {
+					failed = append(failed, gin.H{"id": b.ID, "error": err.Error()})
+					continue
+				}
+				deleted++
+			}
+			c.JSON(200, gin.H{"deleted": deleted, "failed": failed})
+		})
+
+		v1.GET("/storage", admin, adminScope, func(c *gin.Context) {
+			c.JSON(200, summarizeStorage(c.Request.Context(), db, orch))
+		})
+
+		v1.POST("/backups/:backupId/verify", deployer, backupScope, backupAccess, func(c *gin.Context) {
+			backupId := c.Param("backupId")
+			var backup models.Backup
+			if err := db.First(&backup, backupId).Error; err != nil {
+				c.JSON(404, gin.H{"error": "Backup not found"})
+				return
+			}
+
+			if err := verifyBackup(c.Request.Context(), db, backupStores, backupEnc, &backup); err != nil {
+				c.JSON(422, gin.H{"error": err.Error(), "backup": backup})
+				return
+			}
+			c.JSON(200, gin.H{"backup": backup})
+		})
+
+		v1.POST("/projects/:id/deploy", deployer, deployScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var project models.Project
-			if err := db.Preload("EnvVars").Preload("Volumes").First(&project, id).Error; err != nil {
+			if err := db.Preload("EnvVars").Preload("Volumes").Preload("Addons").First(&project, id).Error; err != nil {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 				return
 			}
 
-			cancelMutex.Lock()
-			if cancel, exists := deploymentCancels[project.ID]; exists {
-				cancel()
-			}
-			ctx, cancel := context.WithCancel(context.Background())
-			deploymentCancels[project.ID] = cancel
-			cancelMutex.Unlock()
-
-			go handleDeploy(ctx, db, orch, hub, project)
+			// A build already running finishes; this one waits behind it and
+			// takes the place of any other deploy still waiting.
+			ctx, done := startDeploy(project.ID)
+			go func() {
+				defer done()
+				handleDeploy(ctx, db, orch, hub, project, nil)
+			}()
 			c.JSON(http.StatusAccepted, gin.H{"message": "Deployment started"})
 		})
 
-		v1.POST("/projects/:id/deploy/cancel", func(c *gin.Context) {
+		v1.POST("/projects/:id/deploy/cancel", deployer, deployScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var project models.Project
 			if err := db.First(&project, id).Error; err != nil {
@@ -396,37 +752,32 @@ func main() {
 				return
 			}
 
-			cancelMutex.Lock()
-			if cancel, exists := deploymentCancels[project.ID]; exists {
-				cancel()
-				delete(deploymentCancels, project.ID)
-				cancelMutex.Unlock()
-
-				var deployment models.Deployment
-				db.Where("project_id = ? AND status = ?", project.ID, models.StatusBuilding).Order("id DESC").First(&deployment)
-				if deployment.ID != 0 {
-					updateDeploymentStatus(db, &deployment, models.StatusFailed, "Deployment cancelled by user.")
+			if cancelDeploys(project.ID) {
+				var deployments []models.Deployment
+				db.Where("project_id = ? AND status IN ?", project.ID, []models.DeploymentStatus{models.StatusQueued, models.StatusBuilding}).Find(&deployments)
+				for i := range deployments {
+					updateDeploymentStatus(db, &deployments[i], models.StatusFailed, "Deployment cancelled by user.")
 				}
 				hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
 				c.JSON(200, gin.H{"message": "Deployment cancelled"})
 			} else {
-				cancelMutex.Unlock()
 				c.JSON(400, gin.H{"error": "No active deployment found to cancel"})
 			}
 		})
 
-		v1.POST("/projects/:id/clear-data", func(c *gin.Context) {
+		v1.POST("/projects/:id/clear-data", admin, adminScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var project models.Project
-			if err := db.Preload("Deployments").First(&project, id).Error; err != nil {
+			if err := db.Preload("Deployments").Preload("Volumes").First(&project, id).Error; err != nil {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 				return
 			}
 
+			replicaBalancer.close(project.ID)
 			for _, d := range project.Deployments {
-				if d.ContainerID != "" {
-					orch.StopContainer(context.Background(), d.ContainerID)
-					orch.RemoveContainer(context.Background(), d.ContainerID)
+				for _, id := range d.ContainerIDs() {
+					orch.StopContainer(context.Background(), id)
+					orch.RemoveContainer(context.Background(), id)
 				}
 			}
 
@@ -435,13 +786,16 @@ func main() {
 				c.JSON(500, gin.H{"error": "Failed to delete project data: " + err.Error()})
 				return
 			}
+			os.RemoveAll(uploadsDir(project.ID))
+			os.RemoveAll(buildCacheDir(project.ID))
+			removeNamedVolumes(context.Background(), orch, project.Volumes)
 
 			db.Model(&models.Deployment{}).Where("project_id = ?", project.ID).Update("status", "cleared")
 
 			c.JSON(200, gin.H{"message": "Project data cleared successfully"})
 		})
 
-		v1.POST("/projects/:id/pause", func(c *gin.Context) {
+		v1.POST("/projects/:id/pause", deployer, deployScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var deployments []models.Deployment
 			db.Where("project_id = ? AND container_id != ''", id).Order("id DESC").Find(&deployments)
@@ -449,7 +803,12 @@ func main() {
 			if len(deployments) > 0 {
 				latest := &deployments[0]
 				fmt.Printf("Pausing container %s for project %s\n", latest.ContainerID, id)
-				err := orch.StopContainer(context.Background(), latest.ContainerID)
+				var err error
+				for _, containerID := range latest.ContainerIDs() {
+					if stopErr := orch.StopContainer(context.Background(), containerID); stopErr != nil && err == nil {
+						err = stopErr
+					}
+				}
 
 				if err == nil || strings.Contains(err.Error(), "already stopped") {
 					latest.Status = models.StatusPaused
@@ -461,6 +820,7 @@ func main() {
 				} else if strings.Contains(err.Error(), "No such container") {
 					latest.Status = models.StatusFailed
 					latest.ContainerID = ""
+					latest.ReplicaIDs = nil
 					db.Save(latest)
 					c.JSON(400, gin.H{"error": "Container no longer exists"})
 					return
@@ -472,7 +832,7 @@ func main() {
 			c.JSON(400, gin.H{"error": "No running container found to pause"})
 		})
 
-		v1.POST("/projects/:id/resume", func(c *gin.Context) {
+		v1.POST("/projects/:id/resume", deployer, deployScope, projectAccess, func(c *gin.Context) {
 			id := c.Param("id")
 			var deployments []models.Deployment
 			db.Where("project_id = ? AND container_id != ''", id).Order("id DESC").Find(&deployments)
@@ -480,7 +840,12 @@ func main() {
 			if len(deployments) > 0 {
 				latest := &deployments[0]
 				fmt.Printf("Resuming container %s for project %s\n", latest.ContainerID, id)
-				err := orch.StartContainer(context.Background(), latest.ContainerID)
+				var err error
+				for _, containerID := range latest.ContainerIDs() {
+					if startErr := orch.StartContainer(context.Background(), containerID); startErr != nil && err == nil {
+						err = startErr
+					}
+				}
 
 				if err == nil || strings.Contains(err.Error(), "already started") {
 					latest.Status = models.StatusReady
@@ -492,6 +857,7 @@ func main() {
 				} else if strings.Contains(err.Error(), "No such container") {
 					latest.Status = models.StatusFailed
 					latest.ContainerID = ""
+					latest.ReplicaIDs = nil
 					db.Save(latest)
 					c.JSON(400, gin.H{"error": "Container no longer exists"})
 					return
@@ -512,27 +878,73 @@ func main() {
 	r.Run(":" + port)
 }
 
-func handleDeploy(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, hub *Hub, project models.Project) {
-	defer func() {
-		cancelMutex.Lock()
-		if cancel, exists := deploymentCancels[project.ID]; exists {
-			select {
-			case <-ctx.Done():
-				delete(deploymentCancels, project.ID)
-			default:
-			}
-			_ = cancel
-		}
-		cancelMutex.Unlock()
-	}()
-
+// handleDeploy builds (or pulls) and rolls out a new deployment of project.
+// upload is the source archive of an upload deploy, nil otherwise.
+func handleDeploy(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, hub *Hub, project models.Project, upload *sourceUpload) {
 	deployment := models.Deployment{
 		ProjectID: project.ID,
-		Status:    models.StatusBuilding,
+		Status:    models.StatusQueued,
 	}
 	db.Create(&deployment)
+	hub.BroadcastStatus(project.ID, string(models.StatusQueued), 0)
+
+	// The volume policy may have been tightened since the volumes were added.
+	for i := range project.Volumes {
+		if err := checkVolume(&project.Volumes[i]); err != nil {
+			updateDeploymentStatus(db, &deployment, models.StatusFailed, "Refusing to mount volume: "+err.Error())
+			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+			return
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		updateDeploymentStatus(db, &deployment, models.StatusFailed, "Deployment cancelled.")
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return
+	default:
+	}
+
+	lease, err := builds.acquire(ctx, project.ID)
+	if err == errSuperseded {
+		updateDeploymentStatus(db, &deployment, models.StatusCancelled, "Superseded by a newer deployment.")
+		hub.BroadcastStatus(project.ID, string(models.StatusCancelled), 0)
+		return
+	} else if err != nil {
+		updateDeploymentStatus(db, &deployment, models.StatusFailed, "Deployment cancelled.")
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return
+	}
+	defer lease.release()
+	deployment.Status = models.StatusBuilding
+	db.Save(&deployment)
 	hub.BroadcastStatus(project.ID, string(models.StatusBuilding), 0)
 
+	// Only the build counts against the timeout and the concurrency limit;
+	// the rollout after it doesn't hold up other projects' builds. The
+	// project itself stays locked until the cutover is done.
+	timeout := projectBuildTimeout(project)
+	buildCtx, cancelBuild := context.WithTimeout(ctx, timeout)
+	imageName := fmt.Sprintf("orchestro-p%d", project.ID)
+	var built bool
+	if project.Type == models.ProjectTypeImage && upload == nil {
+		built = pullProjectImage(buildCtx, db, orch, hub, project, &deployment, imageName)
+	} else {
+		built = buildProjectImage(buildCtx, db, orch, hub, project, &deployment, imageName, upload)
+	}
+	timedOut := errors.Is(buildCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+	cancelBuild()
+	lease.releaseSlot()
+	if !built {
+		if timedOut {
+			updateDeploymentStatus(db, &deployment, models.StatusTimedOut, deployment.Logs+fmt.Sprintf("\nBuild timed out after %s", timeout))
+			hub.BroadcastStatus(project.ID, string(models.StatusTimedOut), 0)
+		}
+		return
+	}
+	// From here on the deploy runs its own image, not the project tag.
+	image := recordDeploymentImage(ctx, db, orch, &deployment, imageName)
+
 	select {
 	case <-ctx.Done():
 		updateDeploymentStatus(db, &deployment, models.StatusFailed, "Deployment cancelled.")
@@ -540,6 +952,110 @@ func handleDeploy(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrc
 	default:
 	}
 
+	if err := ensureNamedVolumes(ctx, orch, project.Volumes); err != nil {
+		updateDeploymentStatus(db, &deployment, models.StatusFailed, err.Error())
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return
+	}
+	if err := ensureProjectNetworks(ctx, orch, project); err != nil {
+		updateDeploymentStatus(db, &deployment, models.StatusFailed, err.Error())
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return
+	}
+	if err := ensureAddons(ctx, db, orch, project); err != nil {
+		updateDeploymentStatus(db, &deployment, models.StatusFailed, err.Error())
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return
+	}
+	env := projectEnv(project)
+	volumes := projectBinds(project)
+
+	// The release command runs against the new image while the old container
+	// still serves traffic; a failure leaves it serving.
+	if project.ReleaseCommand != "" {
+		hub.BroadcastLogs(project.ID, "Running release command...\n")
+		releaseLogs, exitCode, err := runRelease(ctx, orch, hub, project, image, deployment.ID, env, volumes, projectNetworks(project, false))
+		deployment.Logs += "\n" + releaseLogs
+		if err != nil || exitCode != 0 {
+			reason := fmt.Sprintf("exited with code %d", exitCode)
+			if err != nil {
+				reason = err.Error()
+			}
+			updateDeploymentStatus(db, &deployment, models.StatusFailed, deployment.Logs+"\nRelease command failed: "+reason)
+			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+			return
+		}
+		db.Save(&deployment)
+	}
+
+	// Internal projects publish nothing and give their port back.
+	port := 0
+	if project.Internal {
+		releasePorts(db, project.ID)
+		replicaBalancer.close(project.ID)
+	} else if port, err = deployPort(db, project); err != nil {
+		updateDeploymentStatus(db, &deployment, models.StatusFailed, deployment.Logs+"\n"+err.Error())
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return
+	}
+	networks := projectNetworks(project, true)
+
+	if project.Replicas > 1 {
+		if err := rolloutReplicas(ctx, db, orch, hub, project, &deployment, image, port, env, volumes, networks); err != nil {
+			updateDeploymentStatus(db, &deployment, models.StatusFailed, deployment.Logs+"\nRollout failed: "+err.Error())
+			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+			return
+		}
+		deployment.Port = port
+		updateDeploymentStatus(db, &deployment, models.StatusReady, deployment.Logs+fmt.Sprintf("\nDeployment successful (%d replicas)", project.Replicas))
+		hub.BroadcastStatus(project.ID, string(models.StatusReady), port)
+		fmt.Printf("Project %d deployed successfully on port %d with %d replicas\n", project.ID, port, project.Replicas)
+		return
+	}
+
+	// Back to a single container: it publishes the port itself again.
+	replicaBalancer.close(project.ID)
+
+	var oldDeployments []models.Deployment
+	db.Where("project_id = ? AND container_id != ''", project.ID).Find(&oldDeployments)
+	for _, oldDep := range oldDeployments {
+		for _, id := range oldDep.ContainerIDs() {
+			fmt.Printf("Stopping old container %s for project %d\n", id, project.ID)
+			orch.StopContainer(context.Background(), id)
+			orch.RemoveContainer(context.Background(), id)
+		}
+		db.Model(&oldDep).Updates(map[string]interface{}{
+			"container_id": "",
+			"replica_ids":  nil,
+			"status":       "outdated",
+		})
+	}
+
+	containerName := fmt.Sprintf("orchestro-c%d-%d", project.ID, deployment.ID)
+	fmt.Printf("Starting container %s\n", containerName)
+	hub.BroadcastLogs(project.ID, "Starting container...\n")
+
+	containerID, err := orch.RunContainer(ctx, image, containerName, port, project.InternalPort, env, volumes, networks)
+	if err != nil {
+		if containerID != "" {
+			orch.RemoveContainer(context.Background(), containerID)
+		}
+		updateDeploymentStatus(db, &deployment, models.StatusFailed, "Failed to run container: "+err.Error())
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return
+	}
+
+	deployment.ContainerID = containerID
+	deployment.Port = port
+	updateDeploymentStatus(db, &deployment, models.StatusReady, deployment.Logs+"\nDeployment successful")
+	hub.BroadcastStatus(project.ID, string(models.StatusReady), port)
+	fmt.Printf("Project %d deployed successfully on port %d\n", project.ID, port)
+}
+
+// buildProjectImage checks out the project's repository, or takes the
+// uploaded source when there is one, and builds it into imageName. On failure
+// the deployment is marked failed and false returned.
+func buildProjectImage(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, hub *Hub, project models.Project, deployment *models.Deployment, imageName string, upload *sourceUpload) bool {
 	projectBaseDir := filepath.Join("data", "projects")
 	if _, err := os.Stat(projectBaseDir); os.IsNotExist(err) {
 		os.MkdirAll(projectBaseDir, 0755)
@@ -547,57 +1063,94 @@ func handleDeploy(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrc
 
 	projectDir := filepath.Join(projectBaseDir, fmt.Sprintf("%d", project.ID))
 
-	if _, err := os.Stat(filepath.Join(projectDir, ".git")); os.IsNotExist(err) {
+	if upload != nil {
+		projectDir = upload.Dir
+		deployment.SourceChecksum = upload.Checksum
+		hub.BroadcastLogs(project.ID, fmt.Sprintf("Using uploaded source %s\n", upload.Checksum))
+	} else if _, err := os.Stat(filepath.Join(projectDir, ".git")); os.IsNotExist(err) {
 		fmt.Printf("Cloning %s into %s\n", project.RepoURL, projectDir)
 		hub.BroadcastLogs(project.ID, "Cloning repository...\n")
-		cmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1", "-b", project.Branch, project.RepoURL, projectDir)
+		cmd := gitCommand(ctx, "clone", "--depth", "1", "-b", project.Branch, project.RepoURL, projectDir)
 		if out, err := cmd.CombinedOutput(); err != nil {
-			updateDeploymentStatus(db, &deployment, models.StatusFailed, "Git clone failed: "+string(out))
+			updateDeploymentStatus(db, deployment, models.StatusFailed, "Git clone failed: "+string(out))
 			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
-			return
+			return false
 		}
 	} else {
 		fmt.Printf("Updating repository in %s\n", projectDir)
 		hub.BroadcastLogs(project.ID, "Updating repository...\n")
-		cmd := exec.CommandContext(ctx, "git", "-C", projectDir, "fetch", "origin", project.Branch)
+		cmd := gitCommand(ctx, "-C", projectDir, "fetch", "origin", project.Branch)
 		if out, err := cmd.CombinedOutput(); err != nil {
-			updateDeploymentStatus(db, &deployment, models.StatusFailed, "Git fetch failed: "+string(out))
+			updateDeploymentStatus(db, deployment, models.StatusFailed, "Git fetch failed: "+string(out))
 			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
-			return
+			return false
 		}
-		cmd = exec.CommandContext(ctx, "git", "-C", projectDir, "reset", "--hard", "origin/"+project.Branch)
+		cmd = gitCommand(ctx, "-C", projectDir, "reset", "--hard", "origin/"+project.Branch)
 		if out, err := cmd.CombinedOutput(); err != nil {
-			updateDeploymentStatus(db, &deployment, models.StatusFailed, "Git reset failed: "+string(out))
+			updateDeploymentStatus(db, deployment, models.StatusFailed, "Git reset failed: "+string(out))
 			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
-			return
+			return false
 		}
 	}
 
 	select {
 	case <-ctx.Done():
-		updateDeploymentStatus(db, &deployment, models.StatusFailed, "Deployment cancelled.")
-		return
+		updateDeploymentStatus(db, deployment, models.StatusFailed, "Deployment cancelled.")
+		return false
 	default:
 	}
 
-	workDir := projectDir
-	if project.RootDirectory != "" {
-		workDir = filepath.Join(projectDir, project.RootDirectory)
+	contextDir := project.BuildContext
+	if contextDir == "" {
+		contextDir = project.RootDirectory
+	}
+	workDir, err := repoDir(projectDir, contextDir)
+	if err != nil {
+		updateDeploymentStatus(db, deployment, models.StatusFailed, "Invalid build context: "+err.Error())
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return false
+	}
+
+	buildKit := orch.BuildKitAvailable()
+	if buildKit {
+		hub.BroadcastLogs(project.ID, "Building with BuildKit...\n")
 	}
 
-	dockerfileName := "Dockerfile"
-	dockerfilePath := filepath.Join(workDir, dockerfileName)
+	// Dockerfiles are written next to the checkout, not into it, so a repo's
+	// own Dockerfile is never overwritten. The repo's Dockerfile is copied
+	// there too, read through an os.Root so a symlink can't pull in a file
+	// from elsewhere on the host.
+	dockerfileDir, err := os.MkdirTemp("", "orchestro-dockerfile-*")
+	if err != nil {
+		updateDeploymentStatus(db, deployment, models.StatusFailed, "Failed to write Dockerfile: "+err.Error())
+		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+		return false
+	}
+	defer os.RemoveAll(dockerfileDir)
+	dockerfilePath := filepath.Join(dockerfileDir, "Dockerfile")
 
-	var err error
 	if project.CustomDockerfile != "" {
 		fmt.Println("Using custom Dockerfile...")
 		hub.BroadcastLogs(project.ID, "Using custom Dockerfile...\n")
 		err = os.WriteFile(dockerfilePath, []byte(project.CustomDockerfile), 0644)
 		if err != nil {
-			updateDeploymentStatus(db, &deployment, models.StatusFailed, "Failed to write custom Dockerfile: "+err.Error())
+			updateDeploymentStatus(db, deployment, models.StatusFailed, "Failed to write custom Dockerfile: "+err.Error())
 			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
-			return
+			return false
+		}
+	} else if project.DockerfilePath != "" {
+		dockerfile, err := readRepoFile(projectDir, project.DockerfilePath)
+		if err != nil {
+			updateDeploymentStatus(db, deployment, models.StatusFailed, fmt.Sprintf("Dockerfile %s not found in the repository", project.DockerfilePath))
+			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+			return false
+		}
+		if err := os.WriteFile(dockerfilePath, dockerfile, 0644); err != nil {
+			updateDeploymentStatus(db, deployment, models.StatusFailed, "Failed to write Dockerfile: "+err.Error())
+			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
+			return false
 		}
+		hub.BroadcastLogs(project.ID, fmt.Sprintf("Using %s...\n", project.DockerfilePath))
 	} else {
 		installCmd := project.InstallCommand
 		if installCmd == "" {
@@ -607,7 +1160,7 @@ func handleDeploy(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrc
 
 		buildStep := ""
 		if buildCmd != "" {
-			buildStep = fmt.Sprintf("RUN %s", buildCmd)
+			buildStep = fmt.Sprintf("RUN %s", withPackageCaches(project, buildCmd, buildKit))
 		}
 
 		startCmd := project.StartCommand
@@ -653,130 +1206,102 @@ COPY . .
 %s
 EXPOSE %d
 CMD ["sh", "-c", "%s"]
-`, baseImage, envInject, copyStep, installCmd, buildStep, intPort, startCmd)
+`, baseImage, envInject, copyStep, withPackageCaches(project, installCmd, buildKit), buildStep, intPort, startCmd)
 
 		err = os.WriteFile(dockerfilePath, []byte(dockerfileContent), 0644)
 		if err != nil {
-			updateDeploymentStatus(db, &deployment, models.StatusFailed, "Failed to generate Dockerfile: "+err.Error())
+			updateDeploymentStatus(db, deployment, models.StatusFailed, "Failed to generate Dockerfile: "+err.Error())
 			hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
-			return
+			return false
 		}
 	}
 
-	imageName := fmt.Sprintf("orchestro-p%d", project.ID)
-
 	buildArgs := make(map[string]*string)
 	for _, ev := range project.EnvVars {
 		val := ev.Value
 		buildArgs[ev.Key] = &val
 	}
+	for key, value := range project.BuildArgs {
+		val := value
+		buildArgs[key] = &val
+	}
 
-	buildLogs, err := orch.BuildImage(ctx, workDir, imageName, dockerfileName, buildArgs, func(line string) {
+	buildLogs, err := orch.BuildImage(ctx, imageName, orchestrator.BuildOptions{
+		ContextDir: workDir,
+		Dockerfile: dockerfilePath,
+		Target:     project.BuildTarget,
+		BuildArgs:  buildArgs,
+		Excludes:   project.BuildExcludes,
+		BuildKit:   buildKit,
+		CacheDir:   buildCacheDir(project.ID),
+		Labels:     map[string]string{imageProjectLabel: fmt.Sprintf("%d", project.ID)},
+	}, func(line string) {
 		hub.BroadcastLogs(project.ID, line)
 	})
 
 	deployment.Logs = buildLogs
 	if err != nil {
-		updateDeploymentStatus(db, &deployment, models.StatusFailed, "Build failed: "+err.Error()+"\nLogs:\n"+buildLogs)
+		updateDeploymentStatus(db, deployment, models.StatusFailed, "Build failed: "+err.Error()+"\nLogs:\n"+buildLogs)
 		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
-		return
-	}
-	db.Save(&deployment)
-
-	select {
-	case <-ctx.Done():
-		updateDeploymentStatus(db, &deployment, models.StatusFailed, "Deployment cancelled.")
-		return
-	default:
+		return false
 	}
+	db.Save(deployment)
+	return true
+}
 
-	var oldDeployments []models.Deployment
-	db.Where("project_id = ? AND container_id != ''", project.ID).Find(&oldDeployments)
-	for _, oldDep := range oldDeployments {
-		fmt.Printf("Stopping old container %s for project %d\n", oldDep.ContainerID, project.ID)
-		orch.StopContainer(context.Background(), oldDep.ContainerID)
-		orch.RemoveContainer(context.Background(), oldDep.ContainerID)
-		db.Model(&oldDep).Updates(map[string]interface{}{
-			"container_id": "",
-			"status":       "outdated",
-		})
+// repoDir resolves dir inside the checkout at repo, symlinks included, and
+// fails when it ends up outside of it.
+func repoDir(repo string, dir string) (string, error) {
+	base, err := filepath.Abs(repo)
+	if err != nil {
+		return "", err
 	}
-
-	containerName := fmt.Sprintf("orchestro-c%d-%d", project.ID, deployment.ID)
-	fmt.Printf("Starting container %s\n", containerName)
-	hub.BroadcastLogs(project.ID, "Starting container...\n")
-
-	port := project.CustomPort
-	if port == 0 {
-		port = 3000 + int(project.ID)
+	if base, err = filepath.EvalSymlinks(base); err != nil {
+		return "", err
 	}
-
-	var env []string
-	for _, ev := range project.EnvVars {
-		env = append(env, fmt.Sprintf("%s=%s", ev.Key, ev.Value))
+	resolved, err := filepath.EvalSymlinks(filepath.Join(base, dir))
+	if err != nil {
+		return "", fmt.Errorf("%s not found in the repository", dir)
 	}
-
-	var volumes []string
-	for _, v := range project.Volumes {
-		fmt.Printf("Configuring volume: %s -> %s\n", v.HostPath, v.ContainerPath)
-		volumes = append(volumes, fmt.Sprintf("%s:%s", v.HostPath, v.ContainerPath))
+	if !within(resolved, base) {
+		return "", fmt.Errorf("%s points outside the repository", dir)
 	}
-
-	containerID, err := orch.RunContainer(ctx, imageName, containerName, port, project.InternalPort, env, volumes)
-	if err != nil {
-		if containerID != "" {
-			orch.RemoveContainer(context.Background(), containerID)
-		}
-		updateDeploymentStatus(db, &deployment, models.StatusFailed, "Failed to run container: "+err.Error())
-		hub.BroadcastStatus(project.ID, string(models.StatusFailed), 0)
-		return
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
 	}
-
-	deployment.ContainerID = containerID
-	deployment.Port = port
-	updateDeploymentStatus(db, &deployment, models.StatusReady, deployment.Logs+"\nDeployment successful")
-	hub.BroadcastStatus(project.ID, string(models.StatusReady), port)
-	fmt.Printf("Project %d deployed successfully on port %d\n", project.ID, port)
+	return resolved, nil
 }
 
-func handleBackup(db *gorm.DB, project models.Project) (models.Backup, error) {
-	backupDir := "data/backups"
-	if _, err := os.Stat(backupDir); os.IsNotExist(err) {
-		os.MkdirAll(backupDir, 0755)
-	}
-
-	timestamp := time.Now().Format("20060102-150405")
-	tempDbPath := filepath.Join(os.TempDir(), fmt.Sprintf("orchestro-%s.db", timestamp))
-	backupPath := filepath.Join(backupDir, fmt.Sprintf("backup-%d-%s.tar.gz", project.ID, timestamp))
-
-	if err := db.Exec(fmt.Sprintf("VACUUM INTO '%s'", tempDbPath)).Error; err != nil {
-		return models.Backup{}, fmt.Errorf("failed to vacuum database: %v", err)
+// readRepoFile reads a regular file from the checkout in dir. Symlinks are
+// followed only as long as they stay inside it.
+func readRepoFile(dir string, name string) ([]byte, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
 	}
-	defer os.Remove(tempDbPath)
-
-	args := []string{"-czf", backupPath, "-C", filepath.Dir(tempDbPath), filepath.Base(tempDbPath)}
-
-	for _, v := range project.Volumes {
-		if _, err := os.Stat(v.HostPath); err == nil {
-			args = append(args, v.HostPath)
-		}
+	defer root.Close()
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
 	}
-
-	cmd := exec.Command("tar", args...)
-	if err := cmd.Run(); err != nil {
-		return models.Backup{}, fmt.Errorf("failed to create backup tar: %v", err)
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
 	}
-
-	fileInfo, _ := os.Stat(backupPath)
-	backup := models.Backup{
-		ProjectID: project.ID,
-		CreatedAt: time.Now(),
-		FilePath:  backupPath,
-		Size:      fileInfo.Size(),
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a file", name)
 	}
-	db.Create(&backup)
+	return io.ReadAll(io.LimitReader(f, 1<<20))
+}
 
-	return backup, nil
+// gitCommand runs git under ctx. git hands the transfer to helper processes
+// that inherit its output, so without a WaitDelay a cancelled clone would
+// still wait for them to finish.
+func gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
 }
 
 func updateDeploymentStatus(db *gorm.DB, d *models.Deployment, status models.DeploymentStatus, logs string) {
@@ -7,30 +7,62 @@ import (
 )
 
 type Project struct {
-	ID               uint           `gorm:"primaryKey" json:"id"`
-	CreatedAt        time.Time      `json:"created_at"`
-	UpdatedAt        time.Time      `json:"updated_at"`
-	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
-	Name             string         `gorm:"uniqueIndex;not null" json:"name"`
-	RepoURL          string         `json:"repo_url"`
-	BaseImage        string         `json:"base_image" gorm:"default:'oven/bun:latest'"`
-	Branch           string         `json:"branch" gorm:"default:'main'"`
-	RootDirectory    string         `json:"root_directory" gorm:"default:''"`
-	BuildCommand     string         `json:"build_command"`
-	InstallCommand   string         `json:"install_command"`
-	StartCommand     string         `json:"start_command" gorm:"default:'bun run start'"`
-	OutputDirectory  string         `json:"output_directory" gorm:"default:'dist'"`
-	CustomPort       int            `json:"custom_port"`
-	InternalPort     int            `json:"internal_port" gorm:"default:80"`
-	EnvVars          []EnvVar       `json:"env_vars" gorm:"foreignKey:ProjectID"`
-	Deployments      []Deployment   `json:"deployments" gorm:"foreignKey:ProjectID"`
-	Backups          []Backup       `json:"backups" gorm:"foreignKey:ProjectID"`
-	Volumes          []Volume       `json:"volumes" gorm:"foreignKey:ProjectID"`
-	WebhookSecret    string         `json:"webhook_secret"`
-	GitProvider      string         `json:"git_provider"` // "github" or "gitlab"
-	WebhookBranch    string         `json:"webhook_branch"`
-	DockerCompose    string         `json:"docker_compose" gorm:"type:text"`
-	CustomDockerfile string         `json:"custom_dockerfile" gorm:"type:text"`
+	ID               uint              `gorm:"primaryKey" json:"id"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt    `gorm:"index" json:"-"`
+	Name             string            `gorm:"uniqueIndex;not null" json:"name"`
+	Type             ProjectType       `json:"type" gorm:"default:'git'"`
+	RepoURL          string            `json:"repo_url"`
+	Image            string            `json:"image"` // for "image" projects, e.g. ghcr.io/org/app:1.2 or app@sha256:...
+	RegistryUsername string            `json:"registry_username"`
+	RegistryPassword string            `json:"registry_password"`
+	BaseImage        string            `json:"base_image" gorm:"default:'oven/bun:latest'"`
+	Branch           string            `json:"branch" gorm:"default:'main'"`
+	RootDirectory    string            `json:"root_directory" gorm:"default:''"`
+	BuildCommand     string            `json:"build_command"`
+	InstallCommand   string            `json:"install_command"`
+	StartCommand     string            `json:"start_command" gorm:"default:'bun run start'"`
+	OutputDirectory  string            `json:"output_directory" gorm:"default:'dist'"`
+	CustomPort       int               `json:"custom_port"`
+	InternalPort     int               `json:"internal_port" gorm:"default:80"`
+	Replicas         int               `json:"replicas" gorm:"default:1"`
+	EnvVars          []EnvVar          `json:"env_vars" gorm:"foreignKey:ProjectID"`
+	Deployments      []Deployment      `json:"deployments" gorm:"foreignKey:ProjectID"`
+	Backups          []Backup          `json:"backups" gorm:"foreignKey:ProjectID"`
+	Volumes          []Volume          `json:"volumes" gorm:"foreignKey:ProjectID"`
+	Addons           []Addon           `json:"addons" gorm:"foreignKey:ProjectID"`
+	WebhookSecret    string            `json:"webhook_secret"`
+	GitProvider      string            `json:"git_provider"` // "github" or "gitlab"
+	WebhookBranch    string            `json:"webhook_branch"`
+	DockerCompose    string            `json:"docker_compose" gorm:"type:text"`
+	CustomDockerfile string            `json:"custom_dockerfile" gorm:"type:text"`
+	DockerfilePath   string            `json:"dockerfile_path"` // relative to the repo; empty generates one
+	BuildContext     string            `json:"build_context"`   // relative to the repo; defaults to root_directory
+	BuildTarget      string            `json:"build_target"`
+	BuildArgs        map[string]string `json:"build_args" gorm:"serializer:json"`
+	BuildTimeout     int               `json:"build_timeout_seconds"`                 // 0 uses BUILD_TIMEOUT_MINUTES
+	BuildExcludes    []string          `json:"build_excludes" gorm:"serializer:json"` // .dockerignore-style patterns on top of the repo's own
+	BackupMode       BackupMode        `json:"backup_mode" gorm:"default:'live'"`
+	BackupPreHook    string            `json:"backup_pre_hook"`
+	BackupPostHook   string            `json:"backup_post_hook"`
+	TerminalShell    string            `json:"terminal_shell"`  // defaults to /bin/sh
+	ReleaseCommand   string            `json:"release_command"` // runs from each new image before cutover, e.g. migrations
+	Networks         []string          `json:"networks" gorm:"serializer:json"`
+	NetworkAlias     string            `json:"network_alias"` // DNS name on its networks
+	Internal         bool              `json:"internal"`      // only reachable over its networks, no host port
+	CacheVersion     int               `json:"-"`             // bumped to drop its BuildKit cache mounts
+}
+
+type ProjectType string
+
+const (
+	ProjectTypeGit   ProjectType = "git"
+	ProjectTypeImage ProjectType = "image"
+)
+
+func (t ProjectType) Valid() bool {
+	return t == "" || t == ProjectTypeGit || t == ProjectTypeImage
 }
 
 type EnvVar struct {
@@ -40,19 +72,79 @@ type EnvVar struct {
 	Value     string `json:"value"`
 }
 
+type BackupScope string
+
+const (
+	BackupScopeProject  BackupScope = "project"
+	BackupScopeInstance BackupScope = "instance"
+)
+
+// BackupMode controls how a project's container is treated while its volumes
+// are being archived.
+type BackupMode string
+
+const (
+	BackupModeLive  BackupMode = "live"  // archive while the container keeps running
+	BackupModePause BackupMode = "pause" // freeze the container with docker pause
+	BackupModeStop  BackupMode = "stop"  // stop the container and start it again afterwards
+	BackupModeHook  BackupMode = "hook"  // run BackupPreHook/BackupPostHook inside the container
+)
+
+func (m BackupMode) Valid() bool {
+	switch m {
+	case "", BackupModeLive, BackupModePause, BackupModeStop, BackupModeHook:
+		return true
+	}
+	return false
+}
+
+type BackupIntegrity string
+
+const (
+	IntegrityUnverified BackupIntegrity = ""
+	IntegrityOK         BackupIntegrity = "ok"
+	IntegrityCorrupted  BackupIntegrity = "corrupted"
+)
+
 type Backup struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	ProjectID uint      `json:"project_id"`
-	CreatedAt time.Time `json:"created_at"`
-	FilePath  string    `json:"file_path"`
-	Size      int64     `json:"size"`
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	ProjectID  uint            `json:"project_id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Scope      BackupScope     `json:"scope" gorm:"default:'project'"`
+	Mode       BackupMode      `json:"mode"`
+	Target     string          `json:"target" gorm:"default:'local'"`
+	FilePath   string          `json:"file_path"`
+	Size       int64           `json:"size"`
+	SHA256     string          `json:"sha256"`
+	Encrypted  bool            `json:"encrypted"`
+	Integrity  BackupIntegrity `json:"integrity"`
+	VerifiedAt *time.Time      `json:"verified_at"`
 }
 
+type VolumeType string
+
+const (
+	VolumeTypeBind  VolumeType = "bind"  // a directory on the host
+	VolumeTypeNamed VolumeType = "named" // a Docker volume created and owned by Orchestro
+)
+
 type Volume struct {
-	ID            uint   `gorm:"primaryKey" json:"id"`
-	ProjectID     uint   `json:"project_id"`
-	HostPath      string `json:"host_path"`
-	ContainerPath string `json:"container_path"`
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	ProjectID     uint       `json:"project_id"`
+	Type          VolumeType `json:"type" gorm:"default:'bind'"`
+	HostPath      string     `json:"host_path"`
+	Name          string     `json:"name"` // Docker volume name, for named volumes
+	ContainerPath string     `json:"container_path"`
+	// Size is filled in from Docker for named volumes when reported.
+	Size *int64 `json:"size,omitempty" gorm:"-"`
+}
+
+// Source is what gets mounted: the host path or the Docker volume name.
+func (v Volume) Source() string {
+	if v.Type == VolumeTypeNamed {
+		return v.Name
+	}
+	return v.HostPath
 }
 
 type DeploymentStatus string
@@ -64,6 +156,8 @@ const (
 	StatusFailed    DeploymentStatus = "failed"
 	StatusPaused    DeploymentStatus = "paused"
 	StatusCancelled DeploymentStatus = "cancelled"
+	StatusQueued    DeploymentStatus = "queued"
+	StatusTimedOut  DeploymentStatus = "timed_out"
 )
 
 type Deployment struct {
@@ -72,8 +166,211 @@ type Deployment struct {
 	CreatedAt   time.Time        `json:"created_at"`
 	Status      DeploymentStatus `json:"status"`
 	CommitHash  string           `json:"commit_hash"`
-	Logs        string           `json:"logs" gorm:"type:text"`
-	ContainerID string           `json:"container_id"`
-	Port        int              `json:"port"`
-	IsPaused    bool             `json:"is_paused"`
+	ImageDigest string           `json:"image_digest,omitempty"` // what an image project's deployment pulled
+	ImageID     string           `json:"image_id,omitempty"`     // the image it runs, also tagged orchestro-p<project>:d<id>
+	// SourceChecksum is the SHA-256 of the archive an upload deploy was
+	// built from, in place of a commit.
+	SourceChecksum string `json:"source_checksum,omitempty"`
+	Logs           string `json:"logs" gorm:"type:text"`
+	ContainerID    string `json:"container_id"`
+	Port           int    `json:"port"`
+	IsPaused       bool   `json:"is_paused"`
+	// ReplicaIDs lists every container of a deployment with more than one
+	// replica. ContainerID is then the first of them.
+	ReplicaIDs []string `json:"replica_ids,omitempty" gorm:"serializer:json"`
+}
+
+// ContainerIDs returns all containers of the deployment.
+func (d Deployment) ContainerIDs() []string {
+	if len(d.ReplicaIDs) > 0 {
+		return d.ReplicaIDs
+	}
+	if d.ContainerID != "" {
+		return []string{d.ContainerID}
+	}
+	return nil
+}
+
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleDeployer Role = "deployer"
+	RoleAdmin    Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	return r == RoleViewer || r == RoleDeployer || r == RoleAdmin
+}
+
+// Allows reports whether r grants at least the permissions of required.
+func (r Role) Allows(required Role) bool {
+	rank := map[Role]int{RoleViewer: 1, RoleDeployer: 2, RoleAdmin: 3}
+	return rank[r] >= rank[required]
+}
+
+type User struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Username     string    `gorm:"uniqueIndex;not null" json:"username"`
+	PasswordHash string    `json:"-"`
+	Role         Role      `json:"role" gorm:"default:'viewer'"`
+	// Restricted users only see the projects they are a member of.
+	Restricted bool      `json:"restricted"`
+	Projects   []Project `json:"projects,omitempty" gorm:"many2many:project_members"`
+}
+
+type Session struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	TokenHash string    `gorm:"uniqueIndex" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// API token scopes. A token can never do more than its owner's role allows.
+const (
+	ScopeDeployRead  = "deploy:read"  // read projects, deployments and logs
+	ScopeDeployWrite = "deploy:write" // deploy, cancel, pause, resume, change settings and volumes
+	ScopeEnvWrite    = "env:write"    // add and remove env vars
+	ScopeBackups     = "backups"      // create, download, verify and delete backups
+	ScopeAdmin       = "admin"        // everything the owner may do, including admin routes
+)
+
+var APITokenScopes = []string{ScopeDeployRead, ScopeDeployWrite, ScopeEnvWrite, ScopeBackups, ScopeAdmin}
+
+type APIToken struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"index" json:"user_id"`
+	Name   string `json:"name"`
+	// ProjectID limits the token to a single project when set.
+	ProjectID  *uint      `json:"project_id"`
+	TokenHash  string     `gorm:"uniqueIndex" json:"-"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditEvent records one mutating request. Changes maps each changed field to
+// its "from" and "to" values, with secrets redacted.
+type AuditEvent struct {
+	ID        uint                   `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time              `gorm:"index" json:"created_at"`
+	Actor     string                 `gorm:"index" json:"actor"`
+	ActorID   *uint                  `json:"actor_id"`
+	TokenID   *uint                  `json:"token_id"`
+	IP        string                 `json:"ip"`
+	Action    string                 `gorm:"index" json:"action"`
+	Method    string                 `json:"method"`
+	Path      string                 `json:"path"`
+	Status    int                    `json:"status"`
+	ProjectID *uint                  `gorm:"index" json:"project_id"`
+	Changes   map[string]interface{} `gorm:"serializer:json" json:"changes,omitempty"`
+}
+
+type CronOverlapPolicy string
+
+const (
+	CronOverlapForbid  CronOverlapPolicy = "forbid"  // skip a run while the previous one is still going
+	CronOverlapAllow   CronOverlapPolicy = "allow"   // run concurrently
+	CronOverlapReplace CronOverlapPolicy = "replace" // stop the previous run and start a new one
+)
+
+func (p CronOverlapPolicy) Valid() bool {
+	switch p {
+	case CronOverlapForbid, CronOverlapAllow, CronOverlapReplace:
+		return true
+	}
+	return false
+}
+
+// CronJob runs Command on Schedule (standard 5-field cron syntax or
+// descriptors like @hourly) in a short-lived container from the project's
+// current image.
+type CronJob struct {
+	ID             uint              `gorm:"primaryKey" json:"id"`
+	ProjectID      uint              `gorm:"index" json:"project_id"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	Name           string            `json:"name"`
+	Schedule       string            `json:"schedule"`
+	Command        string            `json:"command"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	OverlapPolicy  CronOverlapPolicy `json:"overlap_policy"`
+	Enabled        bool              `json:"enabled"`
+	LastRunAt      *time.Time        `json:"last_run_at"`
+	NextRunAt      *time.Time        `json:"next_run_at" gorm:"-"`
+}
+
+type CronRunStatus string
+
+const (
+	CronRunRunning   CronRunStatus = "running"
+	CronRunSucceeded CronRunStatus = "succeeded"
+	CronRunFailed    CronRunStatus = "failed"
+	CronRunTimedOut  CronRunStatus = "timed_out"
+	CronRunSkipped   CronRunStatus = "skipped"
+	CronRunCancelled CronRunStatus = "cancelled"
+)
+
+type CronRun struct {
+	ID         uint          `gorm:"primaryKey" json:"id"`
+	CronJobID  uint          `gorm:"index" json:"cron_job_id"`
+	ProjectID  uint          `gorm:"index" json:"project_id"`
+	Trigger    string        `json:"trigger"` // "schedule" or "manual"
+	Status     CronRunStatus `json:"status"`
+	ExitCode   *int          `json:"exit_code"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at"`
+	Logs       string        `json:"logs,omitempty" gorm:"type:text"`
+}
+
+// PortAllocation reserves a host port for a project, either the one it asked
+// for with CustomPort or one Orchestro picked from the port range.
+type PortAllocation struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ProjectID uint      `json:"project_id" gorm:"uniqueIndex"`
+	Port      int       `json:"port" gorm:"uniqueIndex"`
+	Custom    bool      `json:"custom"`
+}
+
+type AddonType string
+
+const (
+	AddonPostgres AddonType = "postgres"
+	AddonMySQL    AddonType = "mysql"
+	AddonRedis    AddonType = "redis"
+)
+
+// Addon is a managed service, such as a database, running next to a project
+// on a private network. Its connection URL is injected into the project's
+// containers as EnvVar.
+type Addon struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	ProjectID   uint      `json:"project_id" gorm:"index"`
+	Type        AddonType `json:"type"`
+	Name        string    `json:"name"` // host name on the project's add-on network
+	Version     string    `json:"version"`
+	EnvVar      string    `json:"env_var"`
+	Database    string    `json:"database"`
+	Username    string    `json:"username"`
+	Password    string    `json:"password"`
+	ContainerID string    `json:"container_id"`
+	Status      string    `json:"status"`
+	URL         string    `json:"url,omitempty" gorm:"-"`
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+const maxProjectNetworks = 10
+
+var (
+	networkNamePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,49}$`)
+	networkAliasPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// dockerNetworkName is the Docker network behind one of Orchestro's named
+// networks.
+func dockerNetworkName(name string) string {
+	return "orchestro-net-" + name
+}
+
+// defaultNetworkAlias turns a project name into a DNS label, "My API" into
+// "my-api".
+func defaultNetworkAlias(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	alias := strings.TrimRight(b.String(), "-")
+	if len(alias) > 63 {
+		alias = strings.TrimRight(alias[:63], "-")
+	}
+	return alias
+}
+
+// checkNetworks validates a project's networks and alias before it is saved,
+// filling in the alias from the name when it is empty. Aliases are unique
+// across projects so a name always resolves to one project. A network can
+// only be joined when it is new or every project on it is one the caller can
+// see, so nobody reaches a project's services they have no access to.
+func checkNetworks(db *gorm.DB, c *gin.Context, project *models.Project) error {
+	if project.Internal && len(project.Networks) == 0 {
+		return fmt.Errorf("an internal project must join at least one network")
+	}
+	if len(project.Networks) > maxProjectNetworks {
+		return fmt.Errorf("a project can join at most %d networks", maxProjectNetworks)
+	}
+	seen := make(map[string]bool)
+	var networks []string
+	for _, name := range project.Networks {
+		name = strings.TrimSpace(name)
+		if !networkNamePattern.MatchString(name) {
+			return fmt.Errorf("network name %q must be lowercase letters, digits, '.', '_' or '-'", name)
+		}
+		if strings.HasPrefix(name, "addons-p") {
+			return fmt.Errorf("network name %q is reserved for add-ons", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			networks = append(networks, name)
+		}
+	}
+	project.Networks = networks
+
+	joined := make(map[string]bool)
+	if project.ID != 0 {
+		var stored models.Project
+		if db.Select("networks").First(&stored, project.ID).Error == nil {
+			for _, name := range stored.Networks {
+				joined[name] = true
+			}
+		}
+	}
+	members := networkMembers(db)
+	for _, name := range networks {
+		if joined[name] {
+			continue
+		}
+		for _, p := range members[name] {
+			if p.ID != project.ID && !canAccessProject(db, c, p.ID) {
+				return fmt.Errorf("network %q is not available", name)
+			}
+		}
+	}
+
+	if project.NetworkAlias == "" {
+		project.NetworkAlias = defaultNetworkAlias(project.Name)
+	}
+	if !networkAliasPattern.MatchString(project.NetworkAlias) {
+		return fmt.Errorf("network_alias %q must be a DNS label: lowercase letters, digits and '-'", project.NetworkAlias)
+	}
+	var count int64
+	db.Model(&models.Project{}).Where("network_alias = ? AND id != ?", project.NetworkAlias, project.ID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("network_alias is already in use")
+	}
+	return nil
+}
+
+// joinedNetworks is every network the project's containers join: its own
+// and, once it has add-ons, the one it shares with them.
+func joinedNetworks(project models.Project) []string {
+	networks := append([]string{}, project.Networks...)
+	if len(project.Addons) > 0 {
+		networks = append(networks, addonNetwork(project.ID))
+	}
+	return networks
+}
+
+// ensureProjectNetworks creates the Docker networks a project joins.
+func ensureProjectNetworks(ctx context.Context, orch *orchestrator.DockerOrchestrator, project models.Project) error {
+	for _, name := range joinedNetworks(project) {
+		labels := map[string]string{"orchestro.network": name}
+		if err := orch.EnsureNetwork(ctx, dockerNetworkName(name), labels); err != nil {
+			return fmt.Errorf("failed to create network %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// projectNetworks is what a project's containers join. Only the containers
+// serving traffic get the alias; one-off runs join without it so they are
+// never resolved by other projects.
+func projectNetworks(project models.Project, withAlias bool) orchestrator.Networks {
+	networks := orchestrator.Networks{}
+	for _, name := range joinedNetworks(project) {
+		var aliases []string
+		if withAlias && project.NetworkAlias != "" {
+			aliases = []string{project.NetworkAlias}
+		}
+		networks[dockerNetworkName(name)] = aliases
+	}
+	return networks
+}
+
+// pruneNetworks removes those of the given networks no project joins any
+// more. Networks that still have containers attached stay until the next
+// prune.
+func pruneNetworks(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, names []string) {
+	used := networkMembers(db)
+	for _, name := range names {
+		if len(used[name]) > 0 {
+			continue
+		}
+		if err := orch.RemoveNetwork(ctx, dockerNetworkName(name)); err != nil {
+			fmt.Printf("Failed to remove network %s: %v\n", name, err)
+		}
+	}
+}
+
+// networkMembers maps every network name to the projects that join it.
+func networkMembers(db *gorm.DB) map[string][]models.Project {
+	var projects []models.Project
+	db.Select("id", "name", "networks", "network_alias", "internal").Find(&projects)
+	members := make(map[string][]models.Project)
+	for _, p := range projects {
+		for _, name := range p.Networks {
+			members[name] = append(members[name], p)
+		}
+	}
+	return members
+}
+
+func registerNetworkRoutes(db *gorm.DB, v1 *gin.RouterGroup) {
+	// Lists the networks projects the caller can see have joined, and who
+	// else is on them.
+	v1.GET("/networks", requireScope(models.ScopeDeployRead), func(c *gin.Context) {
+		var visible []uint
+		visibleProjects(db, c).Model(&models.Project{}).Pluck("id", &visible)
+		canSee := make(map[uint]bool)
+		for _, id := range visible {
+			canSee[id] = true
+		}
+
+		type member struct {
+			ID       uint   `json:"id"`
+			Name     string `json:"name"`
+			Alias    string `json:"alias"`
+			Internal bool   `json:"internal"`
+		}
+		type networkInfo struct {
+			Name       string   `json:"name"`
+			DockerName string   `json:"docker_name"`
+			Projects   []member `json:"projects"`
+		}
+
+		networks := []networkInfo{}
+		for name, projects := range networkMembers(db) {
+			info := networkInfo{Name: name, DockerName: dockerNetworkName(name), Projects: []member{}}
+			for _, p := range projects {
+				if canSee[p.ID] {
+					info.Projects = append(info.Projects, member{ID: p.ID, Name: p.Name, Alias: p.NetworkAlias, Internal: p.Internal})
+				}
+			}
+			if len(info.Projects) > 0 {
+				networks = append(networks, info)
+			}
+		}
+		sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+		c.JSON(200, networks)
+	})
+}
@@ -0,0 +1,134 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// buildKitBuilder is the buildx builder Orchestro builds with. It uses the
+// docker-container driver, since the default docker driver can't export
+// cache to a directory.
+const buildKitBuilder = "orchestro"
+
+// BuildKitAvailable reports whether builds can go through BuildKit, which
+// needs the docker CLI with buildx on the host. The builder is created on
+// the first call. DOCKER_BUILDKIT=0 turns BuildKit off.
+func (d *DockerOrchestrator) BuildKitAvailable() bool {
+	if os.Getenv("DOCKER_BUILDKIT") == "0" {
+		return false
+	}
+	d.buildKitOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := ensureBuilder(ctx); err != nil {
+			fmt.Printf("BuildKit unavailable, using the classic builder: %v\n", err)
+			return
+		}
+		d.buildKit = true
+	})
+	return d.buildKit
+}
+
+func ensureBuilder(ctx context.Context) error {
+	if out, err := exec.CommandContext(ctx, "docker", "buildx", "version").CombinedOutput(); err != nil {
+		return fmt.Errorf("docker buildx: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	if exec.CommandContext(ctx, "docker", "buildx", "inspect", buildKitBuilder).Run() == nil {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, "docker", "buildx", "create", "--name", buildKitBuilder, "--driver", "docker-container").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to create builder: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// buildWithBuildKit runs docker buildx build on a context tar and loads the
+// result into the daemon. With a CacheDir, layers are imported from it and
+// the new cache is exported next to it and swapped in once the build
+// succeeds, so the directory doesn't keep every old layer forever.
+func buildWithBuildKit(ctx context.Context, imageName string, dockerfileName string, buildCtx io.Reader, opts BuildOptions, onLog func(string)) (string, error) {
+	args := []string{"buildx", "build", "--builder", buildKitBuilder, "--progress=plain", "--load", "-t", imageName, "-f", dockerfileName}
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
+	}
+	for key, value := range opts.Labels {
+		args = append(args, "--label", key+"="+value)
+	}
+
+	// Values go on the command line. Passing them through the environment
+	// would keep them out of ps, but would also hand the project's variables
+	// (LD_PRELOAD, HTTPS_PROXY...) to the docker CLI running on the host.
+	keys := make([]string, 0, len(opts.BuildArgs))
+	for key := range opts.BuildArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := opts.BuildArgs[key]; value != nil {
+			args = append(args, "--build-arg", key+"="+*value)
+		}
+	}
+
+	var newCache string
+	if opts.CacheDir != "" {
+		cacheDir, err := filepath.Abs(opts.CacheDir)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(filepath.Join(cacheDir, "index.json")); err == nil {
+			args = append(args, "--cache-from", "type=local,src="+cacheDir)
+		}
+		newCache = cacheDir + ".new"
+		os.RemoveAll(newCache)
+		if err := os.MkdirAll(filepath.Dir(newCache), 0755); err != nil {
+			return "", err
+		}
+		args = append(args, "--cache-to", "type=local,mode=max,dest="+newCache)
+		defer os.RemoveAll(newCache)
+	}
+	args = append(args, "-")
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = buildCtx
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("docker buildx build failed to start: %v", err)
+	}
+	go func() {
+		pw.CloseWithError(cmd.Wait())
+	}()
+
+	var buildLogs strings.Builder
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text() + "\n"
+		if onLog != nil {
+			onLog(line)
+		}
+		buildLogs.WriteString(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return buildLogs.String(), fmt.Errorf("docker build error: %v", err)
+	}
+
+	if newCache != "" {
+		cacheDir := strings.TrimSuffix(newCache, ".new")
+		os.RemoveAll(cacheDir)
+		if err := os.Rename(newCache, cacheDir); err != nil {
+			fmt.Printf("Failed to save build cache %s: %v\n", cacheDir, err)
+		}
+	}
+	return buildLogs.String(), nil
+}
@@ -1,21 +1,38 @@
 package orchestrator
 
 import (
+	"archive/tar"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/go-connections/nat"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
 )
 
 type DockerOrchestrator struct {
 	cli *client.Client
+
+	buildKitOnce sync.Once
+	buildKit     bool
 }
 
 func NewDockerOrchestrator() (*DockerOrchestrator, error) {
@@ -26,31 +43,79 @@ func NewDockerOrchestrator() (*DockerOrchestrator, error) {
 	return &DockerOrchestrator{cli: cli}, nil
 }
 
-func (d *DockerOrchestrator) BuildImage(ctx context.Context, projectPath string, imageName string, dockerfileName string, buildArgs map[string]*string, onLog func(string)) (string, error) {
-	fmt.Printf("Building image %s from %s with %s\n", imageName, projectPath, dockerfileName)
-	
-	tar, err := archive.TarWithOptions(projectPath, &archive.TarOptions{
-		ExcludePatterns: []string{".git", "node_modules"},
-	})
+// defaultBuildExcludes are left out of every build context. A .dockerignore
+// can bring them back with "!node_modules".
+var defaultBuildExcludes = []string{".git", "node_modules"}
+
+// BuildOptions describes what BuildImage builds.
+type BuildOptions struct {
+	ContextDir string
+	Dockerfile string // path on disk, inside ContextDir or anywhere else
+	Target     string // multi-stage target, "" for the last stage
+	BuildArgs  map[string]*string
+	Excludes   []string // on top of the defaults and the .dockerignore
+	BuildKit   bool     // build with docker buildx, see BuildKitAvailable
+	CacheDir   string   // BuildKit layer cache, imported and exported each build
+	Labels     map[string]string
+}
+
+// contextDockerfile is where a Dockerfile from outside the context is put
+// in the context sent to the daemon.
+const contextDockerfile = ".orchestro.Dockerfile"
+
+// BuildImage builds opts.ContextDir into imageName. The context leaves out
+// the defaults, whatever the .dockerignore in the context dir lists and the
+// extra excludes, in that order, so later patterns win. A Dockerfile outside
+// the context dir is added to the context, as the docker CLI does.
+func (d *DockerOrchestrator) BuildImage(ctx context.Context, imageName string, opts BuildOptions, onLog func(string)) (string, error) {
+	fmt.Printf("Building image %s from %s with %s\n", imageName, opts.ContextDir, opts.Dockerfile)
+
+	dockerfileName, err := filepath.Rel(opts.ContextDir, opts.Dockerfile)
+	var extraDockerfile string
+	if err != nil || dockerfileName == ".." || strings.HasPrefix(dockerfileName, ".."+string(filepath.Separator)) {
+		dockerfileName, extraDockerfile = contextDockerfile, opts.Dockerfile
+	}
+	dockerfileName = filepath.ToSlash(dockerfileName)
+
+	patterns, err := buildExcludes(opts.ContextDir, dockerfileName, opts.Excludes)
 	if err != nil {
-		return "", fmt.Errorf("failed to create tar: %v", err)
+		return "", err
+	}
+	buildCtx, size, err := buildContext(opts.ContextDir, patterns, extraDockerfile)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(buildCtx.Name())
+	defer buildCtx.Close()
+
+	contextLine := fmt.Sprintf("Sending build context (%.1f MB)\n", float64(size)/1024/1024)
+	if onLog != nil {
+		onLog(contextLine)
+	}
+
+	if opts.BuildKit {
+		buildLogs, err := buildWithBuildKit(ctx, imageName, dockerfileName, buildCtx, opts, onLog)
+		return contextLine + buildLogs, err
 	}
 
-	opts := types.ImageBuildOptions{
-		Dockerfile: dockerfileName,
-		Tags:       []string{imageName},
-		Remove:     true,
+	buildOpts := types.ImageBuildOptions{
+		Dockerfile:  dockerfileName,
+		Tags:        []string{imageName},
+		Target:      opts.Target,
+		Remove:      true,
 		ForceRemove: true,
-		BuildArgs:  buildArgs,
+		BuildArgs:   opts.BuildArgs,
+		Labels:      opts.Labels,
 	}
 
-	res, err := d.cli.ImageBuild(ctx, tar, opts)
+	res, err := d.cli.ImageBuild(ctx, buildCtx, buildOpts)
 	if err != nil {
 		return "", fmt.Errorf("docker build request failed: %v", err)
 	}
 	defer res.Body.Close()
 
 	var buildLogs strings.Builder
+	buildLogs.WriteString(contextLine)
 	decoder := json.NewDecoder(res.Body)
 	for {
 		var msg struct {
@@ -77,7 +142,89 @@ func (d *DockerOrchestrator) BuildImage(ctx context.Context, projectPath string,
 	return buildLogs.String(), nil
 }
 
-func (d *DockerOrchestrator) RunContainer(ctx context.Context, imageName string, containerName string, port int, internalPort int, env []string, volumes []string) (string, error) {
+// PullImage pulls ref, logging in with username and password when given, and
+// tags the result as target so it runs like a built image. It returns the
+// digest reference (repo@sha256:...) the pull resolved to.
+func (d *DockerOrchestrator) PullImage(ctx context.Context, ref string, target string, username string, password string, onLog func(string)) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %v", ref, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	opts := image.PullOptions{}
+	if username != "" {
+		auth, err := registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      username,
+			Password:      password,
+			ServerAddress: reference.Domain(named),
+		})
+		if err != nil {
+			return "", err
+		}
+		opts.RegistryAuth = auth
+	}
+
+	progress, err := d.cli.ImagePull(ctx, named.String(), opts)
+	if err != nil {
+		return "", fmt.Errorf("docker pull request failed: %v", err)
+	}
+	defer progress.Close()
+
+	decoder := json.NewDecoder(progress)
+	for {
+		var msg struct {
+			Status   string `json:"status"`
+			ID       string `json:"id"`
+			Progress string `json:"progress"`
+			Error    string `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", fmt.Errorf("failed to decode pull progress: %v", err)
+		}
+		if msg.Error != "" {
+			return "", fmt.Errorf("docker pull error: %s", msg.Error)
+		}
+		// Progress bars would flood the log; layer states are enough.
+		if onLog != nil && msg.Progress == "" && msg.Status != "" {
+			if msg.ID != "" {
+				onLog(msg.ID + ": " + msg.Status + "\n")
+			} else {
+				onLog(msg.Status + "\n")
+			}
+		}
+	}
+
+	inspect, _, err := d.cli.ImageInspectWithRaw(ctx, named.String())
+	if err != nil {
+		return "", err
+	}
+	digest := ""
+	if canonical, ok := named.(reference.Canonical); ok {
+		digest = reference.FamiliarString(canonical)
+	}
+	for _, rd := range inspect.RepoDigests {
+		if digest != "" {
+			break
+		}
+		if repoDigest, err := reference.ParseNormalizedNamed(rd); err == nil && repoDigest.Name() == named.Name() {
+			digest = rd
+		}
+	}
+
+	if err := d.cli.ImageTag(ctx, named.String(), target); err != nil {
+		return digest, err
+	}
+	return digest, nil
+}
+
+// RunContainer starts a container publishing internalPort on the host's port.
+// A zero port publishes nothing; the container is then only reachable on its
+// networks.
+func (d *DockerOrchestrator) RunContainer(ctx context.Context, imageName string, containerName string, port int, internalPort int, env []string, volumes []string, networks Networks) (string, error) {
 	if internalPort == 0 {
 		internalPort = 80
 	}
@@ -93,20 +240,25 @@ func (d *DockerOrchestrator) RunContainer(ctx context.Context, imageName string,
 
 	hostConfig := &container.HostConfig{
 		Binds: volumes,
-		PortBindings: nat.PortMap{
+	}
+	if port != 0 {
+		hostConfig.PortBindings = nat.PortMap{
 			containerPort: []nat.PortBinding{
 				{
 					HostIP:   "0.0.0.0",
 					HostPort: fmt.Sprintf("%d", port),
 				},
 			},
-		},
+		}
 	}
 
 	resp, err := d.cli.ContainerCreate(ctx, config, hostConfig, nil, nil, containerName)
 	if err != nil {
 		return "", err
 	}
+	if err := d.connectNetworks(ctx, resp.ID, networks); err != nil {
+		return resp.ID, err
+	}
 
 	if err := d.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
 		return resp.ID, err
@@ -115,6 +267,55 @@ func (d *DockerOrchestrator) RunContainer(ctx context.Context, imageName string,
 	return resp.ID, nil
 }
 
+// RunReplica starts a container that publishes no host port. Traffic reaches
+// it through Orchestro's load balancer at the address from ContainerAddress.
+func (d *DockerOrchestrator) RunReplica(ctx context.Context, imageName string, containerName string, internalPort int, env []string, volumes []string, networks Networks) (string, error) {
+	if internalPort == 0 {
+		internalPort = 80
+	}
+	resp, err := d.cli.ContainerCreate(ctx, &container.Config{
+		Image:        imageName,
+		Env:          env,
+		ExposedPorts: nat.PortSet{nat.Port(fmt.Sprintf("%d/tcp", internalPort)): {}},
+	}, &container.HostConfig{
+		Binds: volumes,
+	}, nil, nil, containerName)
+	if err != nil {
+		return "", err
+	}
+	if err := d.connectNetworks(ctx, resp.ID, networks); err != nil {
+		return resp.ID, err
+	}
+	if err := d.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return resp.ID, err
+	}
+	return resp.ID, nil
+}
+
+// ContainerAddress returns the IP of a running container on its first
+// network. It changes when the container restarts.
+func (d *DockerOrchestrator) ContainerAddress(ctx context.Context, containerID string) (string, error) {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	if info.State == nil || !info.State.Running {
+		return "", fmt.Errorf("container %s is not running", containerID)
+	}
+	if info.NetworkSettings == nil {
+		return "", fmt.Errorf("container %s has no network", containerID)
+	}
+	if info.NetworkSettings.IPAddress != "" {
+		return info.NetworkSettings.IPAddress, nil
+	}
+	for _, network := range info.NetworkSettings.Networks {
+		if network.IPAddress != "" {
+			return network.IPAddress, nil
+		}
+	}
+	return "", fmt.Errorf("container %s has no IP address", containerID)
+}
+
 func (d *DockerOrchestrator) GetContainerLogs(ctx context.Context, containerID string) (io.ReadCloser, error) {
 	options := container.LogsOptions{
 		ShowStdout: true,
@@ -170,4 +371,499 @@ func (d *DockerOrchestrator) RemoveContainer(ctx context.Context, containerID st
 	return d.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
 }
 
+// ImageSizes returns the size of every local image whose repository name
+// starts with prefix, keyed by repository name (without the tag).
+func (d *DockerOrchestrator) ImageSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	images, err := d.cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64)
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			repo := tag
+			if i := strings.LastIndex(tag, ":"); i != -1 {
+				repo = tag[:i]
+			}
+			if strings.HasPrefix(repo, prefix) {
+				sizes[repo] += img.Size
+				break
+			}
+		}
+	}
+	return sizes, nil
+}
+
+func (d *DockerOrchestrator) PauseContainer(ctx context.Context, containerID string) error {
+	return d.cli.ContainerPause(ctx, containerID)
+}
+
+func (d *DockerOrchestrator) UnpauseContainer(ctx context.Context, containerID string) error {
+	return d.cli.ContainerUnpause(ctx, containerID)
+}
+
+// ExecInContainer runs command through sh -c inside a running container and
+// returns its combined output and exit code.
+func (d *DockerOrchestrator) ExecInContainer(ctx context.Context, containerID string, command string) (string, int, error) {
+	exec, err := d.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          []string{"sh", "-c", command},
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", -1, err
+	}
+
+	attach, err := d.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", -1, err
+	}
+	defer attach.Close()
+
+	var output strings.Builder
+	if _, err := stdcopy.StdCopy(&output, &output, attach.Reader); err != nil {
+		return output.String(), -1, err
+	}
+
+	inspect, err := d.cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return output.String(), -1, err
+	}
+	return output.String(), inspect.ExitCode, nil
+}
+
+// ExecToWriter runs cmd inside a running container and copies its stdout to
+// w as it arrives, for output too large to hold in memory. Stderr is kept and
+// returned as the error when the command exits non-zero.
+func (d *DockerOrchestrator) ExecToWriter(ctx context.Context, containerID string, cmd []string, w io.Writer) (int, error) {
+	exec, err := d.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	attach, err := d.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return -1, err
+	}
+	defer attach.Close()
+
+	var stderr strings.Builder
+	if _, err := stdcopy.StdCopy(w, &stderr, attach.Reader); err != nil {
+		return -1, err
+	}
+
+	inspect, err := d.cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return -1, err
+	}
+	if inspect.ExitCode != 0 {
+		return inspect.ExitCode, fmt.Errorf("exited with code %d: %s", inspect.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return 0, nil
+}
+
+// ExecTTY starts cmd with a TTY in a running container and returns the exec
+// ID with its attached stream. Input written to the stream reaches the
+// command's stdin; its output, stdout and stderr combined, comes back raw.
+func (d *DockerOrchestrator) ExecTTY(ctx context.Context, containerID string, cmd []string, cols, rows uint) (string, types.HijackedResponse, error) {
+	options := container.ExecOptions{
+		Cmd:          cmd,
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          []string{"TERM=xterm-256color"},
+	}
+	if cols > 0 && rows > 0 {
+		options.ConsoleSize = &[2]uint{rows, cols}
+	}
+
+	exec, err := d.cli.ContainerExecCreate(ctx, containerID, options)
+	if err != nil {
+		return "", types.HijackedResponse{}, err
+	}
+	attach, err := d.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{Tty: true, ConsoleSize: options.ConsoleSize})
+	if err != nil {
+		return "", types.HijackedResponse{}, err
+	}
+	return exec.ID, attach, nil
+}
+
+func (d *DockerOrchestrator) ResizeExec(ctx context.Context, execID string, cols, rows uint) error {
+	return d.cli.ContainerExecResize(ctx, execID, container.ResizeOptions{Height: rows, Width: cols})
+}
+
+// ExecExitCode returns the exit code of a finished exec, or -1 while it runs.
+func (d *DockerOrchestrator) ExecExitCode(ctx context.Context, execID string) (int, error) {
+	inspect, err := d.cli.ContainerExecInspect(ctx, execID)
+	if err != nil {
+		return -1, err
+	}
+	if inspect.Running {
+		return -1, nil
+	}
+	return inspect.ExitCode, nil
+}
+
+// RunOneOff runs command through sh -c in a new container from imageName and
+// waits for it to exit, passing output to onOutput as it arrives. The
+// container is always removed afterwards; cancelling ctx kills it.
+func (d *DockerOrchestrator) RunOneOff(ctx context.Context, imageName string, containerName string, command string, env []string, volumes []string, networks Networks, onOutput func(string)) (int, error) {
+	resp, err := d.cli.ContainerCreate(ctx, &container.Config{
+		Image:  imageName,
+		Cmd:    []string{"sh", "-c", command},
+		Env:    env,
+		Labels: map[string]string{"orchestro.oneoff": "true"},
+	}, &container.HostConfig{
+		Binds: volumes,
+	}, nil, nil, containerName)
+	if err != nil {
+		return -1, err
+	}
+	defer d.RemoveContainer(context.Background(), resp.ID)
+	if err := d.connectNetworks(ctx, resp.ID, networks); err != nil {
+		return -1, err
+	}
+
+	attach, err := d.cli.ContainerAttach(ctx, resp.ID, container.AttachOptions{Stream: true, Stdout: true, Stderr: true})
+	if err != nil {
+		return -1, err
+	}
+	defer attach.Close()
+
+	waitCh, errCh := d.cli.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		out := outputWriter(onOutput)
+		stdcopy.StdCopy(out, out, attach.Reader)
+	}()
+
+	if err := d.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		attach.Close()
+		<-copied
+		return -1, err
+	}
+
+	select {
+	case result := <-waitCh:
+		<-copied
+		if result.Error != nil {
+			return int(result.StatusCode), fmt.Errorf("%s", result.Error.Message)
+		}
+		return int(result.StatusCode), nil
+	case err := <-errCh:
+		attach.Close()
+		<-copied
+		return -1, err
+	}
+}
+
+type outputWriter func(string)
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w(string(p))
+	return len(p), nil
+}
+
+// RunService starts a long-running container from a public image, pulling it
+// first when needed. It publishes no ports and is restarted by Docker unless
+// stopped, so it comes back with the host.
+func (d *DockerOrchestrator) RunService(ctx context.Context, imageName string, containerName string, cmd []string, env []string, volumes []string, networks Networks, labels map[string]string) (string, error) {
+	if err := d.ensureImage(ctx, imageName); err != nil {
+		return "", fmt.Errorf("failed to pull %s: %v", imageName, err)
+	}
+	resp, err := d.cli.ContainerCreate(ctx, &container.Config{
+		Image:  imageName,
+		Cmd:    cmd,
+		Env:    env,
+		Labels: labels,
+	}, &container.HostConfig{
+		Binds:         volumes,
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+	}, nil, nil, containerName)
+	if err != nil {
+		return "", err
+	}
+	if err := d.connectNetworks(ctx, resp.ID, networks); err != nil {
+		return resp.ID, err
+	}
+	if err := d.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return resp.ID, err
+	}
+	return resp.ID, nil
+}
+
+// Networks maps Docker network names to the DNS aliases a container gets on
+// each of them.
+type Networks map[string][]string
+
+// connectNetworks attaches a created container to its networks before it
+// starts, so they are there from the first instruction. The default bridge
+// stays, for published ports and outbound traffic.
+func (d *DockerOrchestrator) connectNetworks(ctx context.Context, containerID string, networks Networks) error {
+	for name, aliases := range networks {
+		if err := d.cli.NetworkConnect(ctx, name, containerID, &network.EndpointSettings{Aliases: aliases}); err != nil {
+			return fmt.Errorf("failed to join network %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// EnsureNetwork creates a bridge network unless one with that name exists.
+func (d *DockerOrchestrator) EnsureNetwork(ctx context.Context, name string, labels map[string]string) error {
+	if _, err := d.cli.NetworkInspect(ctx, name, network.InspectOptions{}); err == nil {
+		return nil
+	} else if !errdefs.IsNotFound(err) {
+		return err
+	}
+	_, err := d.cli.NetworkCreate(ctx, name, network.CreateOptions{Driver: "bridge", Labels: labels})
+	return err
+}
+
+// RemoveNetwork deletes a network. One that is gone is not an error; one that
+// still has containers attached is.
+func (d *DockerOrchestrator) RemoveNetwork(ctx context.Context, name string) error {
+	if err := d.cli.NetworkRemove(ctx, name); err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// VolumeHelperImage is used for throwaway containers that read named volumes.
+const VolumeHelperImage = "busybox:latest"
+
+// CreateVolume creates a named volume. Creating one that already exists is a
+// no-op, so it is safe to call before every deploy.
+func (d *DockerOrchestrator) CreateVolume(ctx context.Context, name string, labels map[string]string) error {
+	_, err := d.cli.VolumeCreate(ctx, volume.CreateOptions{Name: name, Labels: labels})
+	return err
+}
+
+// RemoveVolume deletes a named volume. A volume that is already gone is not an
+// error.
+func (d *DockerOrchestrator) RemoveVolume(ctx context.Context, name string) error {
+	if err := d.cli.VolumeRemove(ctx, name, false); err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// VolumeMountpoint returns where a named volume's data lives on the host.
+// Only volumes of the local driver have one.
+func (d *DockerOrchestrator) VolumeMountpoint(ctx context.Context, name string) (string, error) {
+	v, err := d.cli.VolumeInspect(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if v.Driver != "local" || v.Mountpoint == "" {
+		return "", fmt.Errorf("volume %s uses the %s driver and has no local mountpoint", name, v.Driver)
+	}
+	return v.Mountpoint, nil
+}
+
+// VolumeSizes returns the disk usage of every named volume whose name starts
+// with prefix. Docker reports -1 when a size is not available.
+func (d *DockerOrchestrator) VolumeSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	usage, err := d.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64)
+	for _, v := range usage.Volumes {
+		if strings.HasPrefix(v.Name, prefix) && v.UsageData != nil {
+			sizes[v.Name] = v.UsageData.Size
+		}
+	}
+	return sizes, nil
+}
+
+// ExportVolume returns a tar stream of a named volume's contents. It mounts
+// the volume read-only into a helper container that is never started and
+// copies from it, so it works whether or not the project is running. Entries
+// are prefixed with "volume/". Closing the stream removes the helper.
+func (d *DockerOrchestrator) ExportVolume(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := d.ensureImage(ctx, VolumeHelperImage); err != nil {
+		return nil, err
+	}
+
+	resp, err := d.cli.ContainerCreate(ctx, &container.Config{
+		Image: VolumeHelperImage,
+		Cmd:   []string{"true"},
+	}, &container.HostConfig{
+		Binds: []string{name + ":/volume:ro"},
+	}, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	reader, _, err := d.cli.CopyFromContainer(ctx, resp.ID, "/volume")
+	if err != nil {
+		d.RemoveContainer(context.Background(), resp.ID)
+		return nil, err
+	}
+	return &helperStream{ReadCloser: reader, remove: func() {
+		d.RemoveContainer(context.Background(), resp.ID)
+	}}, nil
+}
+
+type helperStream struct {
+	io.ReadCloser
+	remove func()
+}
+
+func (h *helperStream) Close() error {
+	err := h.ReadCloser.Close()
+	h.remove()
+	return err
+}
+
+// ensureImage pulls ref unless it is already present locally.
+func (d *DockerOrchestrator) ensureImage(ctx context.Context, ref string) error {
+	if _, _, err := d.cli.ImageInspectWithRaw(ctx, ref); err == nil {
+		return nil
+	}
+	progress, err := d.cli.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+	_, err = io.Copy(io.Discard, progress)
+	return err
+}
+
+// buildExcludes merges the exclude patterns for a build context. The
+// Dockerfile and .dockerignore are always sent, as the docker CLI does,
+// since the daemon needs them even when they are ignored.
+func buildExcludes(projectPath, dockerfileName string, extra []string) ([]string, error) {
+	patterns := append([]string{}, defaultBuildExcludes...)
+
+	f, err := os.Open(filepath.Join(projectPath, ".dockerignore"))
+	if err == nil {
+		ignored, err := ignorefile.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .dockerignore: %v", err)
+		}
+		patterns = append(patterns, ignored...)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read .dockerignore: %v", err)
+	}
+	patterns = append(patterns, extra...)
+
+	pm, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %v", err)
+	}
+	for _, keep := range []string{filepath.ToSlash(filepath.Clean(dockerfileName)), ".dockerignore"} {
+		if ignored, _ := pm.MatchesOrParentMatches(keep); ignored {
+			patterns = append(patterns, "!"+keep)
+		}
+	}
+	return patterns, nil
+}
+
+// buildContext tars projectPath into a temp file, so its size is known
+// before it is sent, adding the Dockerfile at extraDockerfile if given. The
+// caller removes the file.
+func buildContext(projectPath string, excludes []string, extraDockerfile string) (*os.File, int64, error) {
+	rc, err := archive.TarWithOptions(projectPath, &archive.TarOptions{
+		ExcludePatterns: excludes,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create tar: %v", err)
+	}
+	defer rc.Close()
+
+	f, err := os.CreateTemp("", "orchestro-context-*.tar")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create tar: %v", err)
+	}
+	if extraDockerfile == "" {
+		_, err = io.Copy(f, rc)
+	} else {
+		err = appendDockerfile(f, rc, extraDockerfile)
+	}
+	var size int64
+	if err == nil {
+		size, err = f.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, fmt.Errorf("failed to create tar: %v", err)
+	}
+	return f, size, nil
+}
+
+// appendDockerfile copies the context tar from r to w with the Dockerfile at
+// path added as contextDockerfile. Like the docker CLI, it also lists it in
+// the context's .dockerignore so a "COPY . ." doesn't pick it up. A symlink
+// is refused rather than followed.
+func appendDockerfile(w io.Writer, r io.Reader, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("the Dockerfile %s is not a regular file", path)
+	}
+	dockerfile, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var dockerignore []byte
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Name {
+		case contextDockerfile:
+			continue
+		case ".dockerignore":
+			if dockerignore, err = io.ReadAll(tr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	if len(dockerignore) > 0 && dockerignore[len(dockerignore)-1] != '\n' {
+		dockerignore = append(dockerignore, '\n')
+	}
+	dockerignore = append(dockerignore, contextDockerfile+"\n"...)
+
+	for name, data := range map[string][]byte{".dockerignore": dockerignore, contextDockerfile: dockerfile} {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
 // We will add more methods here like BuildImage, RunContainer, StopContainer
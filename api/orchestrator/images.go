@@ -0,0 +1,117 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-units"
+)
+
+// ImageInfo is an image on the host.
+type ImageInfo struct {
+	ID     string
+	Tags   []string
+	Labels map[string]string
+	Size   int64
+}
+
+// ImageID resolves a tag to the ID of the image behind it.
+func (d *DockerOrchestrator) ImageID(ctx context.Context, ref string) (string, error) {
+	inspect, _, err := d.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return inspect.ID, nil
+}
+
+func (d *DockerOrchestrator) TagImage(ctx context.Context, source string, target string) error {
+	return d.cli.ImageTag(ctx, source, target)
+}
+
+// ListImages returns the images with a tag in one of the repos starting with
+// prefix, and the untagged ones carrying label.
+func (d *DockerOrchestrator) ListImages(ctx context.Context, prefix string, label string) ([]ImageInfo, error) {
+	images, err := d.cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []ImageInfo
+	for _, img := range images {
+		var tags []string
+		for _, tag := range img.RepoTags {
+			if strings.HasPrefix(tag, prefix) {
+				tags = append(tags, tag)
+			}
+		}
+		_, labelled := img.Labels[label]
+		dangling := len(img.RepoTags) == 0 || (len(img.RepoTags) == 1 && img.RepoTags[0] == "<none>:<none>")
+		if len(tags) > 0 || (dangling && labelled) {
+			matched = append(matched, ImageInfo{ID: img.ID, Tags: tags, Labels: img.Labels, Size: img.Size})
+		}
+	}
+	return matched, nil
+}
+
+// RemoveImage removes a tag, or the image when ref is its ID or its last
+// tag. It returns false without an error when a container still uses it.
+func (d *DockerOrchestrator) RemoveImage(ctx context.Context, ref string) (bool, error) {
+	_, err := d.cli.ImageRemove(ctx, ref, image.RemoveOptions{PruneChildren: true})
+	if errdefs.IsConflict(err) {
+		return false, nil
+	}
+	if errdefs.IsNotFound(err) {
+		return true, nil
+	}
+	return err == nil, err
+}
+
+// ImageDiskUsage is the space all image layers take on the host.
+func (d *DockerOrchestrator) ImageDiskUsage(ctx context.Context) (int64, error) {
+	du, err := d.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.ImageObject}})
+	if err != nil {
+		return 0, err
+	}
+	return du.LayersSize, nil
+}
+
+// PruneBuildCache shrinks the daemon's build cache, and the BuildKit
+// builder's when builds go through it, to keepBytes each. It returns the
+// space reclaimed.
+func (d *DockerOrchestrator) PruneBuildCache(ctx context.Context, keepBytes int64) (int64, error) {
+	report, err := d.cli.BuildCachePrune(ctx, types.BuildCachePruneOptions{KeepStorage: keepBytes})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune build cache: %v", err)
+	}
+	reclaimed := int64(report.SpaceReclaimed)
+
+	if d.BuildKitAvailable() {
+		out, err := exec.CommandContext(ctx, "docker", "buildx", "prune", "--builder", buildKitBuilder, "--force", "--keep-storage", fmt.Sprintf("%d", keepBytes)).CombinedOutput()
+		if err != nil {
+			return reclaimed, fmt.Errorf("failed to prune BuildKit cache: %v %s", err, strings.TrimSpace(string(out)))
+		}
+		reclaimed += buildxReclaimed(out)
+	}
+	return reclaimed, nil
+}
+
+// buildxReclaimed reads the "Total: 1.2GB" line buildx prune ends with.
+func buildxReclaimed(out []byte) int64 {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if total, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "Total:"); ok {
+			size, err := units.FromHumanSize(strings.TrimSpace(total))
+			if err == nil {
+				return size
+			}
+		}
+	}
+	return 0
+}
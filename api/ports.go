@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/timuzkas/orchestro/api/models"
+	"gorm.io/gorm"
+)
+
+// portRange is where projects without a CustomPort get their host port from.
+type portRange struct {
+	start int
+	end   int
+}
+
+var hostPorts portRange
+
+// portMu serialises allocations, so two deploys can't pick the same port.
+var portMu sync.Mutex
+
+// loadPortRange reads PORT_RANGE, e.g. "3000-3999" (the default).
+func loadPortRange() (portRange, error) {
+	value := os.Getenv("PORT_RANGE")
+	if value == "" {
+		return portRange{start: 3000, end: 3999}, nil
+	}
+	from, to, ok := strings.Cut(value, "-")
+	start, err1 := strconv.Atoi(strings.TrimSpace(from))
+	end, err2 := strconv.Atoi(strings.TrimSpace(to))
+	if !ok || err1 != nil || err2 != nil || start < 1 || end > 65535 || start > end {
+		return portRange{}, fmt.Errorf("PORT_RANGE %q must look like 3000-3999", value)
+	}
+	return portRange{start: start, end: end}, nil
+}
+
+// portAvailable reports whether nothing on this host listens on port.
+func portAvailable(port int) bool {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// checkCustomPort returns an error if port is reserved by another project.
+func checkCustomPort(db *gorm.DB, projectID uint, port int) error {
+	if port == 0 {
+		return nil
+	}
+	var taken models.PortAllocation
+	if err := db.Where("port = ? AND project_id != ?", port, projectID).First(&taken).Error; err == nil {
+		return fmt.Errorf("port %d is already used by project %d", port, taken.ProjectID)
+	}
+	var count int64
+	db.Model(&models.Project{}).Where("custom_port = ? AND id != ?", port, projectID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("port %d is already used by another project", port)
+	}
+	return nil
+}
+
+// reserveCustomPort records the project's CustomPort as its allocation. A
+// zero port drops a previous custom reservation; the next deploy then picks
+// one from the range.
+func reserveCustomPort(db *gorm.DB, projectID uint, port int) error {
+	portMu.Lock()
+	defer portMu.Unlock()
+
+	if port == 0 {
+		return db.Where("project_id = ? AND custom = ?", projectID, true).Delete(&models.PortAllocation{}).Error
+	}
+	if err := checkCustomPort(db, projectID, port); err != nil {
+		return err
+	}
+	return savePortAllocation(db, projectID, port, true)
+}
+
+func savePortAllocation(db *gorm.DB, projectID uint, port int, custom bool) error {
+	var alloc models.PortAllocation
+	db.Where("project_id = ?", projectID).First(&alloc)
+	alloc.ProjectID = projectID
+	alloc.Port = port
+	alloc.Custom = custom
+	return db.Save(&alloc).Error
+}
+
+// releasePorts frees everything the project had reserved.
+func releasePorts(db *gorm.DB, projectID uint) {
+	portMu.Lock()
+	defer portMu.Unlock()
+	db.Where("project_id = ?", projectID).Delete(&models.PortAllocation{})
+}
+
+// allocatePort returns the project's reserved port, reserving one first if
+// needed. Projects keep the port they were last deployed on when it is free,
+// so existing URLs survive the switch to the allocator.
+func allocatePort(db *gorm.DB, project models.Project) (int, error) {
+	if project.CustomPort != 0 {
+		if err := reserveCustomPort(db, project.ID, project.CustomPort); err != nil {
+			return 0, err
+		}
+		return project.CustomPort, nil
+	}
+
+	portMu.Lock()
+	defer portMu.Unlock()
+
+	var alloc models.PortAllocation
+	if err := db.Where("project_id = ? AND custom = ?", project.ID, false).First(&alloc).Error; err == nil {
+		return alloc.Port, nil
+	}
+
+	taken := make(map[int]bool)
+	var allocations []models.PortAllocation
+	db.Find(&allocations)
+	for _, a := range allocations {
+		taken[a.Port] = true
+	}
+	var customPorts []int
+	db.Model(&models.Project{}).Where("custom_port != 0").Pluck("custom_port", &customPorts)
+	for _, p := range customPorts {
+		taken[p] = true
+	}
+	if apiPort, err := strconv.Atoi(os.Getenv("PORT")); err == nil {
+		taken[apiPort] = true
+	} else {
+		taken[3131] = true
+	}
+
+	var candidates []int
+	var previous models.Deployment
+	if err := db.Where("project_id = ? AND port != 0", project.ID).Order("id DESC").First(&previous).Error; err == nil {
+		candidates = append(candidates, previous.Port)
+	}
+	for p := hostPorts.start; p <= hostPorts.end; p++ {
+		candidates = append(candidates, p)
+	}
+
+	for _, p := range candidates {
+		if taken[p] || !portAvailable(p) {
+			continue
+		}
+		if err := savePortAllocation(db, project.ID, p, false); err != nil {
+			return 0, err
+		}
+		return p, nil
+	}
+	return 0, fmt.Errorf("no free port left in %d-%d", hostPorts.start, hostPorts.end)
+}
+
+// deployPort is the port a new deployment should publish. It has to be free
+// on the host unless the project itself is what holds it; an automatically
+// assigned port that something else took is swapped for a new one.
+func deployPort(db *gorm.DB, project models.Project) (int, error) {
+	port, err := allocatePort(db, project)
+	if err != nil {
+		return 0, err
+	}
+	if portHeldByProject(db, project.ID, port) || portAvailable(port) {
+		return port, nil
+	}
+	if project.CustomPort != 0 {
+		return 0, fmt.Errorf("port %d is already in use on the host", port)
+	}
+
+	portMu.Lock()
+	db.Where("project_id = ?", project.ID).Delete(&models.PortAllocation{})
+	portMu.Unlock()
+	return allocatePort(db, project)
+}
+
+func portHeldByProject(db *gorm.DB, projectID uint, port int) bool {
+	if replicaBalancer.port(projectID) == port {
+		return true
+	}
+	var count int64
+	db.Model(&models.Deployment{}).Where("project_id = ? AND container_id != '' AND port = ?", projectID, port).Count(&count)
+	return count > 0
+}
+
+// backfillPortAllocations reserves the ports of projects deployed before
+// the allocator existed.
+func backfillPortAllocations(db *gorm.DB) {
+	var projects []models.Project
+	db.Where("id NOT IN (?)", db.Model(&models.PortAllocation{}).Select("project_id")).Find(&projects)
+	for _, project := range projects {
+		if project.CustomPort != 0 {
+			if err := reserveCustomPort(db, project.ID, project.CustomPort); err != nil {
+				fmt.Printf("Project %d: %v\n", project.ID, err)
+			}
+			continue
+		}
+		var deployment models.Deployment
+		if err := db.Where("project_id = ? AND container_id != '' AND port != 0", project.ID).Order("id DESC").First(&deployment).Error; err != nil {
+			continue
+		}
+		portMu.Lock()
+		if err := savePortAllocation(db, project.ID, deployment.Port, false); err != nil {
+			fmt.Printf("Project %d: port %d is already reserved: %v\n", project.ID, deployment.Port, err)
+		}
+		portMu.Unlock()
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "github.com/timuzkas/orchestro/api/models"
+
+// projectRequest is what POST and PUT /projects accept. It has no ID and no
+// associations: env vars, volumes and the like go through their own routes
+// and validators. Fields left out of a PUT keep their current value.
+type projectRequest struct {
+	Name             *string             `json:"name"`
+	Type             *models.ProjectType `json:"type"`
+	RepoURL          *string             `json:"repo_url"`
+	Image            *string             `json:"image"`
+	RegistryUsername *string             `json:"registry_username"`
+	RegistryPassword *string             `json:"registry_password"`
+	BaseImage        *string             `json:"base_image"`
+	Branch           *string             `json:"branch"`
+	RootDirectory    *string             `json:"root_directory"`
+	BuildCommand     *string             `json:"build_command"`
+	InstallCommand   *string             `json:"install_command"`
+	StartCommand     *string             `json:"start_command"`
+	OutputDirectory  *string             `json:"output_directory"`
+	CustomPort       *int                `json:"custom_port"`
+	InternalPort     *int                `json:"internal_port"`
+	Replicas         *int                `json:"replicas"`
+	WebhookSecret    *string             `json:"webhook_secret"`
+	GitProvider      *string             `json:"git_provider"`
+	WebhookBranch    *string             `json:"webhook_branch"`
+	DockerCompose    *string             `json:"docker_compose"`
+	CustomDockerfile *string             `json:"custom_dockerfile"`
+	DockerfilePath   *string             `json:"dockerfile_path"`
+	BuildContext     *string             `json:"build_context"`
+	BuildTarget      *string             `json:"build_target"`
+	BuildArgs        *map[string]string  `json:"build_args"`
+	BuildTimeout     *int                `json:"build_timeout_seconds"`
+	BuildExcludes    *[]string           `json:"build_excludes"`
+	BackupMode       *models.BackupMode  `json:"backup_mode"`
+	BackupPreHook    *string             `json:"backup_pre_hook"`
+	BackupPostHook   *string             `json:"backup_post_hook"`
+	TerminalShell    *string             `json:"terminal_shell"`
+	ReleaseCommand   *string             `json:"release_command"`
+	Networks         *[]string           `json:"networks"`
+	NetworkAlias     *string             `json:"network_alias"`
+	Internal         *bool               `json:"internal"`
+}
+
+func setField[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func (r projectRequest) apply(project *models.Project) {
+	setField(&project.Name, r.Name)
+	setField(&project.Type, r.Type)
+	setField(&project.RepoURL, r.RepoURL)
+	setField(&project.Image, r.Image)
+	setField(&project.RegistryUsername, r.RegistryUsername)
+	setField(&project.RegistryPassword, r.RegistryPassword)
+	setField(&project.BaseImage, r.BaseImage)
+	setField(&project.Branch, r.Branch)
+	setField(&project.RootDirectory, r.RootDirectory)
+	setField(&project.BuildCommand, r.BuildCommand)
+	setField(&project.InstallCommand, r.InstallCommand)
+	setField(&project.StartCommand, r.StartCommand)
+	setField(&project.OutputDirectory, r.OutputDirectory)
+	setField(&project.CustomPort, r.CustomPort)
+	setField(&project.InternalPort, r.InternalPort)
+	setField(&project.Replicas, r.Replicas)
+	setField(&project.WebhookSecret, r.WebhookSecret)
+	setField(&project.GitProvider, r.GitProvider)
+	setField(&project.WebhookBranch, r.WebhookBranch)
+	setField(&project.DockerCompose, r.DockerCompose)
+	setField(&project.CustomDockerfile, r.CustomDockerfile)
+	setField(&project.DockerfilePath, r.DockerfilePath)
+	setField(&project.BuildContext, r.BuildContext)
+	setField(&project.BuildTarget, r.BuildTarget)
+	setField(&project.BuildArgs, r.BuildArgs)
+	setField(&project.BuildTimeout, r.BuildTimeout)
+	setField(&project.BuildExcludes, r.BuildExcludes)
+	setField(&project.BackupMode, r.BackupMode)
+	setField(&project.BackupPreHook, r.BackupPreHook)
+	setField(&project.BackupPostHook, r.BackupPostHook)
+	setField(&project.TerminalShell, r.TerminalShell)
+	setField(&project.ReleaseCommand, r.ReleaseCommand)
+	setField(&project.Networks, r.Networks)
+	setField(&project.NetworkAlias, r.NetworkAlias)
+	setField(&project.Internal, r.Internal)
+}
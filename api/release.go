@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+const (
+	releaseTimeout    = 30 * time.Minute
+	defaultRunTimeout = 10 * time.Minute
+	maxRunTimeout     = 6 * time.Hour
+)
+
+// projectEnv is the environment every container of the project gets. Add-on
+// URLs come first so the project's own env vars can override them.
+func projectEnv(project models.Project) []string {
+	env := addonEnv(project.Addons)
+	for _, ev := range project.EnvVars {
+		env = append(env, fmt.Sprintf("%s=%s", ev.Key, ev.Value))
+	}
+	return env
+}
+
+// projectBinds turns the project's volumes into Docker bind specs.
+func projectBinds(project models.Project) []string {
+	var volumes []string
+	for _, v := range project.Volumes {
+		fmt.Printf("Configuring volume: %s -> %s\n", v.Source(), v.ContainerPath)
+		volumes = append(volumes, fmt.Sprintf("%s:%s", v.Source(), v.ContainerPath))
+	}
+	return volumes
+}
+
+// deployedImage is the image of the project's current deployment, which
+// one-off containers run from instead of the last build: that build may
+// never have made it past its release command.
+func deployedImage(db *gorm.DB, projectID uint) (string, error) {
+	var deployment models.Deployment
+	err := db.Where("project_id = ? AND status IN ? AND container_id != ''", projectID, []models.DeploymentStatus{models.StatusReady, models.StatusPaused}).
+		Order("id DESC").First(&deployment).Error
+	if err != nil {
+		return "", fmt.Errorf("the project has no running deployment")
+	}
+	if deployment.ImageID == "" {
+		return "", fmt.Errorf("deployment %d has no recorded image, redeploy the project first", deployment.ID)
+	}
+	return deployment.ImageID, nil
+}
+
+// runRelease runs the project's release command (migrations and the like) in
+// a throwaway container from the freshly built image, streaming its output to
+// the deployment log.
+func runRelease(ctx context.Context, orch *orchestrator.DockerOrchestrator, hub *Hub, project models.Project, imageName string, deploymentID uint, env []string, volumes []string, networks orchestrator.Networks) (string, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, releaseTimeout)
+	defer cancel()
+
+	var logs strings.Builder
+	containerName := fmt.Sprintf("orchestro-release-p%d-%d", project.ID, deploymentID)
+	exitCode, err := orch.RunOneOff(ctx, imageName, containerName, project.ReleaseCommand, env, volumes, networks, func(out string) {
+		logs.WriteString(out)
+		hub.BroadcastLogs(project.ID, out)
+	})
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", releaseTimeout)
+	}
+	return logs.String(), exitCode, err
+}
+
+func registerRunRoutes(db *gorm.DB, orch *orchestrator.DockerOrchestrator, v1 *gin.RouterGroup, projectAccess gin.HandlerFunc) {
+	// Runs a command the same way as the release command. The response is
+	// JSON lines: {"type":"output","data":...} as output arrives, then
+	// {"type":"exit","code":N} or {"type":"error","error":...}.
+	v1.POST("/projects/:id/run", requireRole(terminalRole()), requireScope(models.ScopeDeployWrite), projectAccess, func(c *gin.Context) {
+		var req struct {
+			Command        string `json:"command" binding:"required"`
+			TimeoutSeconds int    `json:"timeout_seconds"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		timeout := defaultRunTimeout
+		if req.TimeoutSeconds > 0 {
+			timeout = time.Duration(req.TimeoutSeconds) * time.Second
+		}
+		if timeout > maxRunTimeout {
+			timeout = maxRunTimeout
+		}
+
+		var project models.Project
+		if err := db.Preload("EnvVars").Preload("Volumes").Preload("Addons").First(&project, c.Param("id")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+		for i := range project.Volumes {
+			if err := checkVolume(&project.Volumes[i]); err != nil {
+				c.JSON(400, gin.H{"error": "Refusing to mount volume: " + err.Error()})
+				return
+			}
+		}
+
+		imageName, err := deployedImage(db, project.ID)
+		if err != nil {
+			c.JSON(409, gin.H{"error": "Nothing to run: " + err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		if err := ensureNamedVolumes(ctx, orch, project.Volumes); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if err := ensureProjectNetworks(ctx, orch, project); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(200)
+		var mu sync.Mutex
+		send := func(v gin.H) {
+			mu.Lock()
+			defer mu.Unlock()
+			line, _ := json.Marshal(v)
+			c.Writer.Write(append(line, '\n'))
+			c.Writer.Flush()
+		}
+
+		containerName := fmt.Sprintf("orchestro-run-p%d-%d", project.ID, time.Now().UnixNano())
+		exitCode, err := orch.RunOneOff(ctx, imageName, containerName, req.Command, projectEnv(project), projectBinds(project), projectNetworks(project, false), func(out string) {
+			send(gin.H{"type": "output", "data": out})
+		})
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+
+		auditAfter(c, gin.H{"command": req.Command, "exit_code": exitCode})
+		if err != nil {
+			send(gin.H{"type": "error", "error": err.Error()})
+			return
+		}
+		send(gin.H{"type": "exit", "code": exitCode})
+	})
+}
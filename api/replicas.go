@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+const (
+	maxReplicas = 20
+	// replicaStartTimeout is how long a new replica gets to accept connections
+	// before the rollout gives up on it.
+	replicaStartTimeout = 2 * time.Minute
+	healthCheckInterval = 5 * time.Second
+	dialTimeout         = 2 * time.Second
+)
+
+// replicaBalancer owns the public port of every project running more than
+// one replica and spreads TCP connections over the healthy ones.
+var replicaBalancer = newLoadBalancer()
+
+type replicaBackend struct {
+	containerID string
+	addr        string
+	healthy     bool
+}
+
+type replicaPool struct {
+	projectID    uint
+	internalPort int
+	listener     net.Listener
+	stop         chan struct{}
+
+	mu       sync.Mutex
+	backends []*replicaBackend
+	next     int
+}
+
+type loadBalancer struct {
+	mu    sync.Mutex
+	orch  *orchestrator.DockerOrchestrator
+	pools map[uint]*replicaPool
+}
+
+func newLoadBalancer() *loadBalancer {
+	return &loadBalancer{pools: make(map[uint]*replicaPool)}
+}
+
+// serve starts listening on the project's public port, unless it already is.
+func (lb *loadBalancer) serve(projectID uint, port int, internalPort int) error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if pool, ok := lb.pools[projectID]; ok {
+		if pool.listener.Addr().(*net.TCPAddr).Port == port {
+			pool.internalPort = internalPort
+			return nil
+		}
+		pool.close()
+		delete(lb.pools, projectID)
+	}
+
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+	pool := &replicaPool{projectID: projectID, internalPort: internalPort, listener: listener, stop: make(chan struct{})}
+	lb.pools[projectID] = pool
+	go pool.accept()
+	go pool.checkHealth(lb.orch)
+	return nil
+}
+
+// close stops balancing a project and frees its port.
+func (lb *loadBalancer) close(projectID uint) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if pool, ok := lb.pools[projectID]; ok {
+		pool.close()
+		delete(lb.pools, projectID)
+	}
+}
+
+// port returns the port the project is balanced on, or 0.
+func (lb *loadBalancer) port(projectID uint) int {
+	if pool := lb.pool(projectID); pool != nil {
+		return pool.listener.Addr().(*net.TCPAddr).Port
+	}
+	return 0
+}
+
+func (lb *loadBalancer) pool(projectID uint) *replicaPool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.pools[projectID]
+}
+
+// add puts a replica into rotation. addr is where it was last seen healthy.
+func (lb *loadBalancer) add(projectID uint, containerID string, addr string) {
+	if pool := lb.pool(projectID); pool != nil {
+		pool.mu.Lock()
+		pool.backends = append(pool.backends, &replicaBackend{containerID: containerID, addr: addr, healthy: addr != ""})
+		pool.mu.Unlock()
+	}
+}
+
+// remove takes a replica out of rotation. Open connections are not cut.
+func (lb *loadBalancer) remove(projectID uint, containerID string) {
+	if pool := lb.pool(projectID); pool != nil {
+		pool.mu.Lock()
+		for i, b := range pool.backends {
+			if b.containerID == containerID {
+				pool.backends = append(pool.backends[:i], pool.backends[i+1:]...)
+				break
+			}
+		}
+		pool.mu.Unlock()
+	}
+}
+
+func (p *replicaPool) close() {
+	close(p.stop)
+	p.listener.Close()
+}
+
+func (p *replicaPool) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			select {
+			case <-p.stop:
+				return
+			default:
+				continue
+			}
+		}
+		go p.proxy(conn)
+	}
+}
+
+// pick returns healthy backends in round-robin order, so a failed dial can
+// fall through to the next one.
+func (p *replicaPool) pick() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var addrs []string
+	n := len(p.backends)
+	for i := 0; i < n; i++ {
+		b := p.backends[(p.next+i)%n]
+		if b.healthy && b.addr != "" {
+			addrs = append(addrs, b.addr)
+		}
+	}
+	if n > 0 {
+		p.next = (p.next + 1) % n
+	}
+	return addrs
+}
+
+func (p *replicaPool) proxy(client net.Conn) {
+	defer client.Close()
+
+	var upstream net.Conn
+	for _, addr := range p.pick() {
+		conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+		if err == nil {
+			upstream = conn
+			break
+		}
+	}
+	if upstream == nil {
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, client)
+		if tcp, ok := upstream.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		if tcp, ok := client.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// checkHealth looks every replica up again and tries to connect to it. The
+// lookup matters: a replica gets a new IP when it is restarted.
+func (p *replicaPool) checkHealth(orch *orchestrator.DockerOrchestrator) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		backends := append([]*replicaBackend{}, p.backends...)
+		port := p.internalPort
+		p.mu.Unlock()
+
+		for _, b := range backends {
+			addr, err := replicaAddress(context.Background(), orch, b.containerID, port)
+			healthy := err == nil && probe(addr)
+			p.mu.Lock()
+			if err == nil {
+				b.addr = addr
+			}
+			b.healthy = healthy
+			p.mu.Unlock()
+		}
+	}
+}
+
+func replicaAddress(ctx context.Context, orch *orchestrator.DockerOrchestrator, containerID string, internalPort int) (string, error) {
+	ip, err := orch.ContainerAddress(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	if internalPort == 0 {
+		internalPort = 80
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(internalPort)), nil
+}
+
+func probe(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// waitForReplica blocks until the replica accepts connections on its
+// internal port and returns its address.
+func waitForReplica(ctx context.Context, orch *orchestrator.DockerOrchestrator, containerID string, internalPort int) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, replicaStartTimeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		addr, err := replicaAddress(ctx, orch, containerID, internalPort)
+		if err == nil && probe(addr) {
+			return addr, nil
+		}
+		if err != nil {
+			lastErr = err
+			if status, _ := orch.GetContainerStatus(ctx, containerID); status == "exited" || status == "dead" {
+				return "", fmt.Errorf("replica exited during startup")
+			}
+		} else {
+			lastErr = fmt.Errorf("nothing is listening on %s", addr)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("replica did not become healthy within %s: %v", replicaStartTimeout, lastErr)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// rolloutReplicas brings up the new deployment's replicas one at a time. Each
+// new replica has to accept connections before it joins the pool, and the old
+// replicas only leave it once every new one has, so capacity never drops
+// during a deploy. If a replica fails, the new ones are removed again and the
+// old replicas, all still there, keep serving.
+func rolloutReplicas(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator, hub *Hub, project models.Project, deployment *models.Deployment, imageName string, port int, env []string, volumes []string, networks orchestrator.Networks) error {
+	var oldDeployments []models.Deployment
+	db.Where("project_id = ? AND container_id != '' AND id != ?", project.ID, deployment.ID).Find(&oldDeployments)
+
+	// Old replicas are retired oldest first, once the new replicas are all
+	// up. A single old container publishes the port itself.
+	type oldReplica struct {
+		deployment  *models.Deployment
+		containerID string
+	}
+	var retireQueue []oldReplica
+	var direct []*models.Deployment
+	for i := range oldDeployments {
+		d := &oldDeployments[i]
+		if len(d.ReplicaIDs) == 0 {
+			direct = append(direct, d)
+			continue
+		}
+		for _, id := range d.ReplicaIDs {
+			retireQueue = append(retireQueue, oldReplica{deployment: d, containerID: id})
+		}
+	}
+
+	// Internal projects (port 0) have no balancer; their replicas share the
+	// network alias and Docker's DNS spreads the load.
+	public := port != 0
+	balancing := len(direct) == 0
+	if balancing && public {
+		if err := replicaBalancer.serve(project.ID, port, project.InternalPort); err != nil {
+			return fmt.Errorf("failed to listen on port %d: %v", port, err)
+		}
+	}
+
+	retire := func(r oldReplica) {
+		replicaBalancer.remove(project.ID, r.containerID)
+		orch.StopContainer(context.Background(), r.containerID)
+		orch.RemoveContainer(context.Background(), r.containerID)
+
+		var remaining []string
+		for _, id := range r.deployment.ReplicaIDs {
+			if id != r.containerID {
+				remaining = append(remaining, id)
+			}
+		}
+		r.deployment.ReplicaIDs = remaining
+		if len(remaining) == 0 {
+			r.deployment.ContainerID = ""
+			r.deployment.Status = "outdated"
+		} else {
+			r.deployment.ContainerID = remaining[0]
+		}
+		db.Save(r.deployment)
+	}
+
+	var started []string
+	type newReplica struct{ containerID, addr string }
+	var ready []newReplica
+	abort := func(err error) error {
+		for _, id := range started {
+			replicaBalancer.remove(project.ID, id)
+			orch.RemoveContainer(context.Background(), id)
+		}
+		if balancing && len(retireQueue) == 0 {
+			// Nothing old was serving; don't hold the port.
+			replicaBalancer.close(project.ID)
+		}
+		return err
+	}
+
+	for i := 0; i < project.Replicas; i++ {
+		containerName := fmt.Sprintf("orchestro-c%d-%d-%d", project.ID, deployment.ID, i)
+		hub.BroadcastLogs(project.ID, fmt.Sprintf("Starting replica %d of %d...\n", i+1, project.Replicas))
+		containerID, err := orch.RunReplica(ctx, imageName, containerName, project.InternalPort, env, volumes, networks)
+		if containerID != "" {
+			started = append(started, containerID)
+		}
+		if err != nil {
+			return abort(fmt.Errorf("failed to start replica %d: %v", i+1, err))
+		}
+
+		addr, err := waitForReplica(ctx, orch, containerID, project.InternalPort)
+		if err != nil {
+			return abort(fmt.Errorf("replica %d: %v", i+1, err))
+		}
+		ready = append(ready, newReplica{containerID, addr})
+
+		if balancing {
+			replicaBalancer.add(project.ID, containerID, addr)
+		}
+	}
+
+	// Everything new is healthy; whatever is old can go.
+	for _, d := range direct {
+		for _, id := range d.ContainerIDs() {
+			orch.StopContainer(context.Background(), id)
+			orch.RemoveContainer(context.Background(), id)
+		}
+		db.Model(d).Updates(map[string]interface{}{"container_id": "", "status": "outdated"})
+	}
+	if !balancing && public {
+		if err := replicaBalancer.serve(project.ID, port, project.InternalPort); err != nil {
+			return abort(fmt.Errorf("failed to listen on port %d: %v", port, err))
+		}
+		for _, r := range ready {
+			replicaBalancer.add(project.ID, r.containerID, r.addr)
+		}
+	}
+	for _, r := range retireQueue {
+		retire(r)
+	}
+
+	deployment.ReplicaIDs = started
+	deployment.ContainerID = started[0]
+	return nil
+}
+
+// restoreReplicaPools puts the balancer back in front of replicated projects
+// after the API restarts.
+func restoreReplicaPools(db *gorm.DB, orch *orchestrator.DockerOrchestrator) {
+	replicaBalancer.orch = orch
+	var deployments []models.Deployment
+	db.Where("container_id != '' AND replica_ids IS NOT NULL AND replica_ids != 'null'").Find(&deployments)
+	for _, d := range deployments {
+		if len(d.ReplicaIDs) == 0 || d.Port == 0 {
+			continue
+		}
+		var project models.Project
+		if err := db.First(&project, d.ProjectID).Error; err != nil {
+			continue
+		}
+		if err := replicaBalancer.serve(project.ID, d.Port, project.InternalPort); err != nil {
+			fmt.Printf("Failed to restore load balancer for project %d: %v\n", project.ID, err)
+			continue
+		}
+		for _, id := range d.ReplicaIDs {
+			replicaBalancer.add(project.ID, id, "")
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3PartSize is the multipart chunk size. minio-go buffers one part in
+// memory at a time, and without a size it would pick ~537 MiB parts for a
+// stream of unknown length. 10000 parts of 32 MiB still allow ~312 GiB.
+const s3PartSize = 32 << 20
+
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	Insecure  bool
+}
+
+// S3Store writes backups to any S3-compatible object storage (AWS, MinIO,
+// Backblaze, R2...). Uploads are streamed as multipart so archives are never
+// staged on local disk.
+type S3Store struct {
+	cli    *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("BACKUP_S3_BUCKET is required when BACKUP_S3_ENDPOINT is set")
+	}
+
+	cli, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %v", err)
+	}
+
+	return &S3Store{
+		cli:    cli,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(cfg.Prefix, "/"),
+	}, nil
+}
+
+func (s *S3Store) Name() string {
+	return "s3"
+}
+
+func (s *S3Store) key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	info, err := s.cli.PutObject(ctx, s.bucket, s.key(key), r, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    s3PartSize,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload backup: %v", err)
+	}
+	return info.Size, nil
+}
+
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.cli.GetObject(ctx, s.bucket, s.key(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; stat it so a missing key fails here and not mid-download.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	return s.cli.RemoveObject(ctx, s.bucket, s.key(key), minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Store is a destination backup archives are streamed to and read back from.
+// Keys are slash separated paths relative to the store's root.
+type Store interface {
+	Name() string
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Registry holds every configured store. The local store is always present so
+// that backups taken before a remote target was configured stay reachable.
+type Registry struct {
+	stores  map[string]Store
+	Default string
+}
+
+func (r *Registry) Get(name string) (Store, error) {
+	if name == "" {
+		name = "local"
+	}
+	s, ok := r.stores[name]
+	if !ok {
+		return nil, fmt.Errorf("backup target %q is not configured", name)
+	}
+	return s, nil
+}
+
+// NewRegistryFromEnv builds the stores from environment variables:
+//
+//	BACKUP_TARGET        local (default) or s3, where new backups are written
+//	BACKUP_LOCAL_DIR     directory for the local target, may be an NFS mount
+//	BACKUP_S3_ENDPOINT   host[:port] of an S3-compatible service
+//	BACKUP_S3_BUCKET     bucket name
+//	BACKUP_S3_PREFIX     optional key prefix inside the bucket
+//	BACKUP_S3_ACCESS_KEY / BACKUP_S3_SECRET_KEY
+//	BACKUP_S3_REGION     optional region
+//	BACKUP_S3_INSECURE   set to true to talk plain http (e.g. local MinIO)
+func NewRegistryFromEnv() (*Registry, error) {
+	localDir := os.Getenv("BACKUP_LOCAL_DIR")
+	if localDir == "" {
+		localDir = "data/backups"
+	}
+
+	r := &Registry{
+		stores:  map[string]Store{},
+		Default: os.Getenv("BACKUP_TARGET"),
+	}
+	r.stores["local"] = NewLocalStore(localDir)
+
+	if endpoint := os.Getenv("BACKUP_S3_ENDPOINT"); endpoint != "" {
+		s3, err := NewS3Store(S3Config{
+			Endpoint:  endpoint,
+			Bucket:    os.Getenv("BACKUP_S3_BUCKET"),
+			Prefix:    os.Getenv("BACKUP_S3_PREFIX"),
+			AccessKey: os.Getenv("BACKUP_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("BACKUP_S3_SECRET_KEY"),
+			Region:    os.Getenv("BACKUP_S3_REGION"),
+			Insecure:  strings.EqualFold(os.Getenv("BACKUP_S3_INSECURE"), "true"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		r.stores["s3"] = s3
+	}
+
+	if r.Default == "" {
+		r.Default = "local"
+	}
+	if _, err := r.Get(r.Default); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
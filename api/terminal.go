@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+const defaultTerminalShell = "/bin/sh"
+
+// terminalMessage is what the browser sends. Keystrokes arrive as "input";
+// "resize" follows the terminal's size. Output goes back as binary frames,
+// and a final {"type":"exit","code":N} text frame ends the session.
+type terminalMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Cols uint   `json:"cols"`
+	Rows uint   `json:"rows"`
+}
+
+// terminalRole is the least role allowed to open a terminal, from
+// TERMINAL_ROLE (deployer by default). A shell can read everything the
+// container can, including env var values.
+func terminalRole() models.Role {
+	role := models.Role(os.Getenv("TERMINAL_ROLE"))
+	if !role.Valid() {
+		return models.RoleDeployer
+	}
+	return role
+}
+
+// checkProjectCommands refuses changes to the commands a project runs in its
+// containers, the release command and the backup hooks, from users below
+// terminalRole(): they would run a shell as surely as the terminal does.
+func checkProjectCommands(c *gin.Context, before, after models.Project) bool {
+	if before.ReleaseCommand == after.ReleaseCommand &&
+		before.BackupPreHook == after.BackupPreHook &&
+		before.BackupPostHook == after.BackupPostHook {
+		return true
+	}
+	if !currentUser(c).Role.Allows(terminalRole()) {
+		c.JSON(403, gin.H{"error": fmt.Sprintf("changing release_command or the backup hooks needs the %s role", terminalRole())})
+		return false
+	}
+	return true
+}
+
+func registerTerminalRoutes(db *gorm.DB, orch *orchestrator.DockerOrchestrator, v1 *gin.RouterGroup, projectAccess gin.HandlerFunc) {
+	v1.GET("/projects/:id/terminal", requireTokenAuth(), requireRole(terminalRole()), requireScope(models.ScopeDeployWrite), projectAccess, func(c *gin.Context) {
+		var project models.Project
+		if err := db.First(&project, c.Param("id")).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+		var deployment models.Deployment
+		if err := db.Where("project_id = ? AND container_id != ''", project.ID).Order("id DESC").First(&deployment).Error; err != nil {
+			c.JSON(400, gin.H{"error": "No running container found"})
+			return
+		}
+
+		shell := c.Query("shell")
+		if shell == "" {
+			shell = project.TerminalShell
+		}
+		if shell == "" {
+			shell = defaultTerminalShell
+		}
+		cols, _ := strconv.ParseUint(c.Query("cols"), 10, 32)
+		rows, _ := strconv.ParseUint(c.Query("rows"), 10, 32)
+
+		execID, stream, err := orch.ExecTTY(c.Request.Context(), deployment.ContainerID, strings.Fields(shell), uint(cols), uint(rows))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to start shell: " + err.Error()})
+			return
+		}
+		defer stream.Close()
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			fmt.Printf("Error upgrading to websocket: %v\n", err)
+			return
+		}
+		defer conn.Close()
+
+		started := time.Now()
+		recordAudit(db, c, "terminal.open", map[string]interface{}{
+			"shell":     map[string]interface{}{"to": shell},
+			"container": map[string]interface{}{"to": deployment.ContainerID},
+		})
+
+		var writeMu sync.Mutex
+		write := func(messageType int, data []byte) error {
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			return conn.WriteMessage(messageType, data)
+		}
+
+		// Container output to the browser. The exec ending closes the socket.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := stream.Reader.Read(buf)
+				if n > 0 {
+					if write(websocket.BinaryMessage, buf[:n]) != nil {
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		go func() {
+			ticker := time.NewTicker(pingPeriod)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if write(websocket.PingMessage, nil) != nil {
+						return
+					}
+				}
+			}
+		}()
+
+		// Browser input to the container, until either side goes away.
+		go func() {
+			for {
+				messageType, data, err := conn.ReadMessage()
+				if err != nil {
+					stream.Close()
+					return
+				}
+				if messageType == websocket.BinaryMessage {
+					stream.Conn.Write(data)
+					continue
+				}
+				var msg terminalMessage
+				if json.Unmarshal(data, &msg) != nil {
+					continue
+				}
+				switch msg.Type {
+				case "input":
+					stream.Conn.Write([]byte(msg.Data))
+				case "resize":
+					if msg.Cols > 0 && msg.Rows > 0 {
+						orch.ResizeExec(context.Background(), execID, msg.Cols, msg.Rows)
+					}
+				}
+			}
+		}()
+
+		<-done
+		exitCode, _ := orch.ExecExitCode(context.Background(), execID)
+		exit, _ := json.Marshal(map[string]interface{}{"type": "exit", "code": exitCode})
+		write(websocket.TextMessage, exit)
+		write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+		recordAudit(db, c, "terminal.close", map[string]interface{}{
+			"duration_seconds": map[string]interface{}{"to": int(time.Since(started).Seconds())},
+			"exit_code":        map[string]interface{}{"to": exitCode},
+		})
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/timuzkas/orchestro/api/models"
+	"gorm.io/gorm"
+)
+
+const apiTokenPrefix = "ot_"
+
+func userForAPIToken(db *gorm.DB, token string) (*models.User, *models.APIToken, bool) {
+	var apiToken models.APIToken
+	if err := db.Where("token_hash = ?", hashToken(token)).First(&apiToken).Error; err != nil {
+		return nil, nil, false
+	}
+	now := time.Now()
+	if apiToken.ExpiresAt != nil && now.After(*apiToken.ExpiresAt) {
+		return nil, nil, false
+	}
+	var user models.User
+	if err := db.First(&user, apiToken.UserID).Error; err != nil {
+		return nil, nil, false
+	}
+
+	// Only record usage once a minute to avoid a write on every request.
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > time.Minute {
+		apiToken.LastUsedAt = &now
+		db.Model(&apiToken).Update("last_used_at", now)
+	}
+	return &user, &apiToken, true
+}
+
+// rejectAPITokens keeps token management behind an interactive login or basic
+// auth, so a leaked token cannot mint new ones.
+func rejectAPITokens(c *gin.Context) {
+	if currentAPIToken(c) != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API tokens cannot manage API tokens"})
+		return
+	}
+	c.Next()
+}
+
+func registerTokenRoutes(db *gorm.DB, v1 *gin.RouterGroup) {
+	tokens := v1.Group("/tokens", rejectAPITokens)
+
+	tokens.GET("", func(c *gin.Context) {
+		var apiTokens []models.APIToken
+		db.Where("user_id = ?", currentUser(c).ID).Order("id DESC").Find(&apiTokens)
+		c.JSON(200, apiTokens)
+	})
+
+	tokens.POST("", func(c *gin.Context) {
+		var req struct {
+			Name          string   `json:"name" binding:"required"`
+			Scopes        []string `json:"scopes" binding:"required"`
+			ProjectID     *uint    `json:"project_id"`
+			ExpiresInDays int      `json:"expires_in_days"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, scope := range req.Scopes {
+			if !validScope(scope) {
+				c.JSON(400, gin.H{"error": "Unknown scope " + scope, "valid_scopes": models.APITokenScopes})
+				return
+			}
+		}
+		if req.ProjectID != nil && !canAccessProject(db, c, *req.ProjectID) {
+			c.JSON(404, gin.H{"error": "Project not found"})
+			return
+		}
+
+		token, hash, err := newToken(apiTokenPrefix)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		apiToken := models.APIToken{
+			UserID:    currentUser(c).ID,
+			Name:      req.Name,
+			ProjectID: req.ProjectID,
+			TokenHash: hash,
+			Prefix:    token[:len(apiTokenPrefix)+8],
+			Scopes:    req.Scopes,
+		}
+		if req.ExpiresInDays > 0 {
+			expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			apiToken.ExpiresAt = &expires
+		}
+		if err := db.Create(&apiToken).Error; err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		auditAfter(c, apiToken)
+		// The plain token is only ever returned here.
+		c.JSON(201, gin.H{"token": token, "api_token": apiToken})
+	})
+
+	tokens.DELETE("/:tokenId", func(c *gin.Context) {
+		user := currentUser(c)
+		query := db.Where("id = ?", c.Param("tokenId"))
+		if user.Role != models.RoleAdmin {
+			query = query.Where("user_id = ?", user.ID)
+		}
+		result := query.Delete(&models.APIToken{})
+		if result.RowsAffected == 0 {
+			c.JSON(404, gin.H{"error": "Token not found"})
+			return
+		}
+		c.Status(204)
+	})
+}
+
+func validScope(scope string) bool {
+	for _, s := range models.APITokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
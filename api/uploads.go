@@ -0,0 +1,375 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+const defaultSourceUploadMB = 200
+
+// sourceUpload is a build context that arrived as an archive instead of
+// from git.
+type sourceUpload struct {
+	Dir      string
+	Checksum string
+}
+
+// sourceUploadLimit is the largest source archive accepted, from
+// SOURCE_UPLOAD_MAX_MB. Unpacked, a source may be ten times that.
+func sourceUploadLimit() int64 {
+	mb, err := strconv.Atoi(os.Getenv("SOURCE_UPLOAD_MAX_MB"))
+	if err != nil || mb <= 0 {
+		mb = defaultSourceUploadMB
+	}
+	return int64(mb) << 20
+}
+
+func uploadsDir(projectID uint) string {
+	return filepath.Join("data", "uploads", fmt.Sprintf("%d", projectID))
+}
+
+// archiveEntry is a file in an uploaded tar or zip archive.
+type archiveEntry struct {
+	name     string
+	mode     fs.FileMode
+	linkname string
+	open     func() (io.ReadCloser, error)
+}
+
+// walkArchive calls fn for every entry of a gzipped tar, plain tar or zip
+// file, telling them apart by their first bytes.
+func walkArchive(f *os.File, fn func(archiveEntry) error) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(512)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return err
+		}
+		for _, zf := range zr.File {
+			entry := archiveEntry{name: zf.Name, mode: zf.Mode(), open: zf.Open}
+			if entry.mode&fs.ModeSymlink != 0 {
+				rc, err := zf.Open()
+				if err != nil {
+					return err
+				}
+				target, err := io.ReadAll(io.LimitReader(rc, 4096))
+				rc.Close()
+				if err != nil {
+					return err
+				}
+				entry.linkname = string(target)
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return walkTar(tar.NewReader(gz), fn)
+
+	case len(magic) > 262 && string(magic[257:262]) == "ustar":
+		return walkTar(tar.NewReader(br), fn)
+	}
+	return fmt.Errorf("the upload is not a tar.gz, tar or zip archive")
+}
+
+func walkTar(tr *tar.Reader, fn func(archiveEntry) error) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry := archiveEntry{
+			name:     hdr.Name,
+			mode:     hdr.FileInfo().Mode(),
+			linkname: hdr.Linkname,
+			open:     func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanEntryName makes an archive path relative and slash separated, or
+// returns "" for names that point outside the archive.
+func cleanEntryName(name string) string {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	if name == "/" || strings.Contains(name, "/../") {
+		return ""
+	}
+	return strings.TrimPrefix(name, "/")
+}
+
+// commonRoot is the single top-level directory every entry lives in, as in
+// the archives GitHub and GitLab offer for download ("repo-main/..."), or "".
+func commonRoot(names []string) string {
+	root := ""
+	for _, name := range names {
+		first, _, nested := strings.Cut(name, "/")
+		if !nested && !strings.HasSuffix(name, "/") {
+			return ""
+		}
+		if root == "" {
+			root = first
+		} else if first != root {
+			return ""
+		}
+	}
+	return root
+}
+
+// extractSource unpacks an uploaded archive into dest. A single wrapping
+// directory is stripped, and paths the source's .dockerignore excludes are
+// never written. Everything goes through an os.Root, so entries can't escape
+// dest, not even through symlinks in the archive.
+func extractSource(f *os.File, dest string, maxBytes int64) error {
+	// First pass: find the root directory and the .dockerignore.
+	var names []string
+	ignoreFiles := make(map[string][]byte)
+	err := walkArchive(f, func(e archiveEntry) error {
+		name := cleanEntryName(e.name)
+		if name == "" {
+			return nil
+		}
+		if e.mode.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+		if path.Base(name) == ".dockerignore" && strings.Count(name, "/") <= 1 && e.mode.IsRegular() {
+			rc, err := e.open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			data, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+			if err != nil {
+				return err
+			}
+			ignoreFiles[name] = data
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("the archive is empty")
+	}
+
+	prefix := ""
+	if root := commonRoot(names); root != "" {
+		prefix = root + "/"
+	}
+	var matcher *patternmatcher.PatternMatcher
+	if data, ok := ignoreFiles[prefix+".dockerignore"]; ok {
+		if matcher, err = loadIgnorePatterns(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf(".dockerignore: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(dest)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	// Second pass: write what is left.
+	var written int64
+	return walkArchive(f, func(e archiveEntry) error {
+		name := strings.TrimPrefix(cleanEntryName(e.name), prefix)
+		if name == "" || name == strings.TrimSuffix(prefix, "/") {
+			return nil
+		}
+		if matcher != nil && name != ".dockerignore" && name != "Dockerfile" {
+			if ignored, err := matcher.MatchesOrParentMatches(name); err == nil && ignored {
+				return nil
+			}
+		}
+
+		switch {
+		case e.mode.IsDir():
+			return root.MkdirAll(name, 0755)
+		case e.mode&fs.ModeSymlink != 0:
+			if err := root.MkdirAll(path.Dir(name), 0755); err != nil {
+				return err
+			}
+			root.Remove(name)
+			return root.Symlink(e.linkname, name)
+		case e.mode.IsRegular():
+			if err := root.MkdirAll(path.Dir(name), 0755); err != nil {
+				return err
+			}
+			rc, err := e.open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			out, err := root.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, e.mode.Perm()|0600)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(out, io.LimitReader(rc, maxBytes-written+1))
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if written += n; written > maxBytes {
+				return fmt.Errorf("the archive unpacks to more than %d MB", maxBytes>>20)
+			}
+			return err
+		}
+		// Devices, fifos and the like have no place in a build context.
+		return nil
+	})
+}
+
+// loadIgnorePatterns parses a .dockerignore.
+func loadIgnorePatterns(r io.Reader) (*patternmatcher.PatternMatcher, error) {
+	patterns, err := ignorefile.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return patternmatcher.New(patterns)
+}
+
+// receiveUpload saves the request body to a temp file, hashing it on the
+// way, and fails once it grows past limit.
+func receiveUpload(c *gin.Context, limit int64) (*os.File, string, error) {
+	f, err := os.CreateTemp("", "orchestro-upload-*")
+	if err != nil {
+		return nil, "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	return f, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func registerUploadRoutes(db *gorm.DB, orch *orchestrator.DockerOrchestrator, hub *Hub, v1 *gin.RouterGroup, projectAccess gin.HandlerFunc) {
+	deployer := requireRole(models.RoleDeployer)
+	deployScope := requireScope(models.ScopeDeployWrite)
+
+	// The body is the source as a tar.gz, tar or zip archive. It replaces the
+	// git checkout for this one deployment.
+	v1.POST("/projects/:id/deploy/upload", deployer, deployScope, projectAccess, func(c *gin.Context) {
+		var project models.Project
+		if err := db.Preload("EnvVars").Preload("Volumes").Preload("Addons").First(&project, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+
+		limit := sourceUploadLimit()
+		f, checksum, err := receiveUpload(c, limit)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Uploads are limited to %d MB", limit>>20)})
+				return
+			}
+			c.JSON(400, gin.H{"error": "Upload failed: " + err.Error()})
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The archive is unpacked next to its final place and moved there
+		// once complete. The same archive may already be there, and a deploy
+		// may be building from it.
+		dir := filepath.Join(uploadsDir(project.ID), checksum[:12])
+		if _, err := os.Stat(dir); err != nil {
+			if err := os.MkdirAll(uploadsDir(project.ID), 0755); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			tmp, err := os.MkdirTemp(uploadsDir(project.ID), ".extract-*")
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			if err := extractSource(f, tmp, 10*limit); err != nil {
+				os.RemoveAll(tmp)
+				c.JSON(400, gin.H{"error": "Invalid archive: " + err.Error()})
+				return
+			}
+			if err := os.Rename(tmp, dir); err != nil {
+				os.RemoveAll(tmp)
+				if _, statErr := os.Stat(dir); statErr != nil {
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+		// Older uploads go once no deploy could still be building from one.
+		if !deploying(project.ID) {
+			if entries, err := os.ReadDir(uploadsDir(project.ID)); err == nil {
+				for _, entry := range entries {
+					if entry.Name() != checksum[:12] && !strings.HasPrefix(entry.Name(), ".") {
+						os.RemoveAll(filepath.Join(uploadsDir(project.ID), entry.Name()))
+					}
+				}
+			}
+		}
+
+		checksum = "sha256:" + checksum
+		auditAfter(c, gin.H{"source_checksum": checksum, "size": info.Size()})
+		ctx, done := startDeploy(project.ID)
+		go func() {
+			defer done()
+			handleDeploy(ctx, db, orch, hub, project, &sourceUpload{Dir: dir, Checksum: checksum})
+		}()
+		c.JSON(http.StatusAccepted, gin.H{"message": "Deployment started", "source_checksum": checksum})
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+	"gorm.io/gorm"
+)
+
+type projectUsage struct {
+	ProjectID         uint   `json:"project_id"`
+	Name              string `json:"name"`
+	BackupCount       int64  `json:"backup_count"`
+	BackupBytes       int64  `json:"backup_bytes"`
+	ImageBytes        int64  `json:"image_bytes"`
+	BuildContextBytes int64  `json:"build_context_bytes"`
+	VolumeBytes       int64  `json:"volume_bytes"`
+}
+
+type diskSpace struct {
+	Path       string `json:"path"`
+	TotalBytes uint64 `json:"total_bytes"`
+	FreeBytes  uint64 `json:"free_bytes"`
+}
+
+type storageSummary struct {
+	Projects            []projectUsage `json:"projects"`
+	InstanceBackupBytes int64          `json:"instance_backup_bytes"`
+	TotalBackupBytes    int64          `json:"total_backup_bytes"`
+	TotalImageBytes     int64          `json:"total_image_bytes"`
+	TotalBuildBytes     int64          `json:"total_build_context_bytes"`
+	TotalVolumeBytes    int64          `json:"total_volume_bytes"`
+	Disk                *diskSpace     `json:"disk"`
+	Errors              []string       `json:"errors,omitempty"`
+}
+
+// summarizeStorage reports what Orchestro keeps on the host: backup sizes as
+// recorded in the database (wherever they are stored), image sizes from
+// Docker, managed named volumes, checked out build contexts under
+// data/projects and free space on the disk holding the data directory. Bind
+// mounted host directories are not counted.
+func summarizeStorage(ctx context.Context, db *gorm.DB, orch *orchestrator.DockerOrchestrator) storageSummary {
+	var summary storageSummary
+
+	var projects []models.Project
+	db.Order("id").Find(&projects)
+
+	type backupTotal struct {
+		ProjectID uint
+		Count     int64
+		Bytes     int64
+	}
+	var totals []backupTotal
+	db.Model(&models.Backup{}).Select("project_id, COUNT(*) AS count, COALESCE(SUM(size), 0) AS bytes").Group("project_id").Scan(&totals)
+	backupsByProject := make(map[uint]backupTotal)
+	for _, t := range totals {
+		backupsByProject[t.ProjectID] = t
+		summary.TotalBackupBytes += t.Bytes
+	}
+	summary.InstanceBackupBytes = backupsByProject[0].Bytes
+
+	images, err := orch.ImageSizes(ctx, "orchestro-")
+	if err != nil {
+		summary.Errors = append(summary.Errors, "images: "+err.Error())
+	}
+	volumes, err := orch.VolumeSizes(ctx, "orchestro-p")
+	if err != nil {
+		summary.Errors = append(summary.Errors, "volumes: "+err.Error())
+	}
+
+	for _, p := range projects {
+		usage := projectUsage{
+			ProjectID:   p.ID,
+			Name:        p.Name,
+			BackupCount: backupsByProject[p.ID].Count,
+			BackupBytes: backupsByProject[p.ID].Bytes,
+			ImageBytes:  images[fmt.Sprintf("orchestro-p%d", p.ID)],
+		}
+		usage.BuildContextBytes, _ = dirSize(filepath.Join("data", "projects", fmt.Sprintf("%d", p.ID)))
+		for name, size := range volumes {
+			if strings.HasPrefix(name, fmt.Sprintf("orchestro-p%d-v", p.ID)) && size > 0 {
+				usage.VolumeBytes += size
+			}
+		}
+
+		summary.TotalImageBytes += usage.ImageBytes
+		summary.TotalBuildBytes += usage.BuildContextBytes
+		summary.TotalVolumeBytes += usage.VolumeBytes
+		summary.Projects = append(summary.Projects, usage)
+	}
+
+	if disk, err := statDisk("data"); err != nil {
+		summary.Errors = append(summary.Errors, "disk: "+err.Error())
+	} else {
+		summary.Disk = disk
+	}
+
+	return summary
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func statDisk(path string) (*diskSpace, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(abs, &st); err != nil {
+		return nil, err
+	}
+	return &diskSpace{
+		Path:       abs,
+		TotalBytes: st.Blocks * uint64(st.Bsize),
+		FreeBytes:  st.Bavail * uint64(st.Bsize),
+	}, nil
+}
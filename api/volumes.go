@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/timuzkas/orchestro/api/models"
+	"github.com/timuzkas/orchestro/api/orchestrator"
+)
+
+// defaultDeniedPaths can never be bind mounted, nor can any directory that
+// contains one of them (mounting /var would expose /var/run/docker.sock).
+var defaultDeniedPaths = []string{
+	"/bin", "/boot", "/dev", "/etc", "/lib", "/lib64", "/proc", "/root", "/run",
+	"/sbin", "/sys", "/usr", "/var/lib/docker", "/var/run", "/var/run/docker.sock",
+}
+
+// hostPathPolicy decides which host directories projects may bind mount.
+type hostPathPolicy struct {
+	allowed []string
+	denied  []string
+}
+
+var volumePolicy *hostPathPolicy
+
+// loadHostPathPolicy reads VOLUME_ALLOWED_PATHS, a comma separated list of
+// base directories volumes must live under, and VOLUME_DENIED_PATHS, which
+// extends the built-in deny list. Orchestro's own data directory is always
+// denied. Without an allowlist any path outside the deny list is accepted.
+func loadHostPathPolicy() (*hostPathPolicy, error) {
+	policy := &hostPathPolicy{}
+
+	for _, p := range splitPathList(os.Getenv("VOLUME_ALLOWED_PATHS")) {
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("VOLUME_ALLOWED_PATHS entry %q is not absolute", p)
+		}
+		policy.allowed = append(policy.allowed, resolvePath(filepath.Clean(p)))
+	}
+
+	denied := append([]string{}, defaultDeniedPaths...)
+	if dataDir, err := filepath.Abs("data"); err == nil {
+		denied = append(denied, dataDir)
+	}
+	denied = append(denied, splitPathList(os.Getenv("VOLUME_DENIED_PATHS"))...)
+	for _, p := range denied {
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("VOLUME_DENIED_PATHS entry %q is not absolute", p)
+		}
+		policy.denied = append(policy.denied, filepath.Clean(p), resolvePath(filepath.Clean(p)))
+	}
+
+	if len(policy.allowed) == 0 {
+		log.Printf("VOLUME_ALLOWED_PATHS is not set; projects may mount any host path outside the deny list")
+	}
+	return policy, nil
+}
+
+func splitPathList(value string) []string {
+	var paths []string
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// resolvePath follows symlinks in the longest existing prefix of p, so a
+// link inside an allowed directory cannot point a mount somewhere else.
+func resolvePath(p string) string {
+	rest := ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(p, rest)
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// within reports whether p is base or lies below it.
+func within(p, base string) bool {
+	if base == "/" {
+		return true
+	}
+	return p == base || strings.HasPrefix(p, base+"/")
+}
+
+// Check validates a host path and returns it normalized.
+func (p *hostPathPolicy) Check(hostPath string) (string, error) {
+	if !filepath.IsAbs(hostPath) {
+		return "", fmt.Errorf("host path %q must be absolute", hostPath)
+	}
+	for _, part := range strings.Split(hostPath, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("host path %q must not contain '..'", hostPath)
+		}
+	}
+
+	clean := filepath.Clean(hostPath)
+	resolved := resolvePath(clean)
+
+	for _, d := range p.denied {
+		for _, candidate := range []string{clean, resolved} {
+			if within(candidate, d) || within(d, candidate) {
+				return "", fmt.Errorf("host path %q is not allowed to be mounted", hostPath)
+			}
+		}
+	}
+
+	if len(p.allowed) > 0 {
+		ok := false
+		for _, a := range p.allowed {
+			if within(resolved, a) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return "", fmt.Errorf("host path %q is outside the allowed volume directories (%s)", hostPath, strings.Join(p.allowed, ", "))
+		}
+	}
+	return clean, nil
+}
+
+// namedVolumeName is the Docker volume backing a named project volume.
+func namedVolumeName(v models.Volume) string {
+	return fmt.Sprintf("orchestro-p%d-v%d", v.ProjectID, v.ID)
+}
+
+// checkVolume validates a volume before it is saved or mounted. A named
+// volume is always the Docker volume Orchestro made for it; a new one gets
+// its name once it has an ID.
+func checkVolume(v *models.Volume) error {
+	if !filepath.IsAbs(v.ContainerPath) {
+		return fmt.Errorf("container path %q must be absolute", v.ContainerPath)
+	}
+	v.ContainerPath = filepath.Clean(v.ContainerPath)
+
+	switch v.Type {
+	case models.VolumeTypeNamed:
+		v.HostPath = ""
+		if v.ID == 0 {
+			v.Name = ""
+			return nil
+		}
+		if strings.Contains(v.Name, "/") || v.Name != namedVolumeName(*v) {
+			return fmt.Errorf("named volume %d is not backed by %s", v.ID, namedVolumeName(*v))
+		}
+		return nil
+	case "", models.VolumeTypeBind:
+		v.Type = models.VolumeTypeBind
+	default:
+		return fmt.Errorf("volume type must be bind or named")
+	}
+
+	hostPath, err := volumePolicy.Check(v.HostPath)
+	if err != nil {
+		return err
+	}
+	v.HostPath = hostPath
+	return nil
+}
+
+// ensureNamedVolumes creates the Docker volumes behind a project's named
+// volumes. It runs before every deploy so volumes removed by clearing the
+// project's data come back empty.
+func ensureNamedVolumes(ctx context.Context, orch *orchestrator.DockerOrchestrator, volumes []models.Volume) error {
+	for _, v := range volumes {
+		if v.Type != models.VolumeTypeNamed {
+			continue
+		}
+		labels := map[string]string{"orchestro.project": fmt.Sprintf("%d", v.ProjectID)}
+		if err := orch.CreateVolume(ctx, v.Name, labels); err != nil {
+			return fmt.Errorf("failed to create volume %s: %v", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// removeNamedVolumes deletes the Docker volumes behind a project's named
+// volumes. Bind mounts are left alone; that data belongs to the host.
+func removeNamedVolumes(ctx context.Context, orch *orchestrator.DockerOrchestrator, volumes []models.Volume) {
+	for _, v := range volumes {
+		if v.Type != models.VolumeTypeNamed {
+			continue
+		}
+		if err := orch.RemoveVolume(ctx, v.Name); err != nil {
+			fmt.Printf("Failed to remove volume %s: %v\n", v.Name, err)
+		}
+	}
+}
+
+// fillVolumeSizes sets Size on named volumes from Docker's disk usage.
+func fillVolumeSizes(ctx context.Context, orch *orchestrator.DockerOrchestrator, volumes []models.Volume) {
+	named := false
+	for _, v := range volumes {
+		named = named || v.Type == models.VolumeTypeNamed
+	}
+	if !named {
+		return
+	}
+
+	sizes, err := orch.VolumeSizes(ctx, "orchestro-")
+	if err != nil {
+		return
+	}
+	for i, v := range volumes {
+		if size, ok := sizes[v.Name]; ok && size >= 0 {
+			volumes[i].Size = &size
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/timuzkas/orchestro/api/models"
+)
+
+func newTestPolicy(t *testing.T, allowed, denied string) *hostPathPolicy {
+	t.Helper()
+	t.Setenv("VOLUME_ALLOWED_PATHS", allowed)
+	t.Setenv("VOLUME_DENIED_PATHS", denied)
+	policy, err := loadHostPathPolicy()
+	if err != nil {
+		t.Fatalf("loadHostPathPolicy: %v", err)
+	}
+	return policy
+}
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadHostPathPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		denied  string
+		wantErr string
+	}{
+		{"empty", "", "", ""},
+		{"absolute lists", "/srv/a, /srv/b", "/srv/a/secret", ""},
+		{"relative allowed", "srv", "", "VOLUME_ALLOWED_PATHS"},
+		{"relative denied", "", "/srv,secret", "VOLUME_DENIED_PATHS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VOLUME_ALLOWED_PATHS", tt.allowed)
+			t.Setenv("VOLUME_DENIED_PATHS", tt.denied)
+			_, err := loadHostPathPolicy()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want one mentioning %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, filepath.Join(dir, "real"), filepath.Join(dir, "link"))
+	symlink(t, "/etc", filepath.Join(dir, "etc"))
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"existing", filepath.Join(dir, "real"), filepath.Join(dir, "real")},
+		{"missing below existing", filepath.Join(dir, "real", "a", "b"), filepath.Join(dir, "real", "a", "b")},
+		{"symlink", filepath.Join(dir, "link"), filepath.Join(dir, "real")},
+		{"missing below symlink", filepath.Join(dir, "link", "a"), filepath.Join(dir, "real", "a")},
+		{"symlink out", filepath.Join(dir, "etc", "x"), filepath.Join(resolvePath("/etc"), "x")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolvePath(tt.path); got != tt.want {
+				t.Fatalf("resolvePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostPathPolicyDenyList(t *testing.T) {
+	dir := t.TempDir()
+	symlink(t, "/etc", filepath.Join(dir, "etc"))
+	symlink(t, "/var/run", filepath.Join(dir, "run"))
+	policy := newTestPolicy(t, "", filepath.Join(dir, "secret"))
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{"plain directory", filepath.Join(dir, "data"), filepath.Join(dir, "data"), ""},
+		{"trailing slash", filepath.Join(dir, "data") + "/", filepath.Join(dir, "data"), ""},
+		{"relative", "data", "", "must be absolute"},
+		{"dot dot", dir + "/data/../data", "", "must not contain '..'"},
+		{"dot dot into deny list", dir + "/../../etc", "", "must not contain '..'"},
+		{"denied", "/etc", "", "not allowed"},
+		{"below denied", "/etc/nginx", "", "not allowed"},
+		{"docker socket", "/var/run/docker.sock", "", "not allowed"},
+		{"parent of denied", "/var", "", "not allowed"},
+		{"root", "/", "", "not allowed"},
+		{"symlink to denied", filepath.Join(dir, "etc"), "", "not allowed"},
+		{"below symlink to denied", filepath.Join(dir, "run", "docker.sock"), "", "not allowed"},
+		{"extra denied", filepath.Join(dir, "secret", "keys"), "", "not allowed"},
+		{"data dir", mustAbs(t, "data"), "", "not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Check(tt.path)
+			checkResult(t, tt.path, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestHostPathPolicyAllowList(t *testing.T) {
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{real, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The allow root is a symlink; paths are checked against its target.
+	alias := filepath.Join(dir, "alias")
+	symlink(t, real, alias)
+	symlink(t, outside, filepath.Join(real, "escape"))
+	symlink(t, "/etc", filepath.Join(real, "etc"))
+	policy := newTestPolicy(t, alias, "")
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{"through the alias", filepath.Join(alias, "app"), filepath.Join(alias, "app"), ""},
+		{"through the target", filepath.Join(real, "app"), filepath.Join(real, "app"), ""},
+		{"allow root itself", alias, alias, ""},
+		{"outside", filepath.Join(outside, "app"), "", "outside the allowed"},
+		{"sibling with shared prefix", real + "-other", "", "outside the allowed"},
+		{"symlink out of the root", filepath.Join(alias, "escape", "app"), "", "outside the allowed"},
+		{"symlink to denied", filepath.Join(alias, "etc"), "", "not allowed"},
+		{"dot dot out of the root", alias + "/../outside", "", "must not contain '..'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Check(tt.path)
+			checkResult(t, tt.path, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestCheckVolume(t *testing.T) {
+	dir := t.TempDir()
+	volumePolicy = newTestPolicy(t, "", "")
+	t.Cleanup(func() { volumePolicy = nil })
+
+	tests := []struct {
+		name     string
+		volume   models.Volume
+		wantName string
+		wantHost string
+		wantErr  string
+	}{
+		{
+			name:   "new named volume gets no name yet",
+			volume: models.Volume{ProjectID: 2, Type: models.VolumeTypeNamed, Name: "other-project", HostPath: "/etc", ContainerPath: "/data"},
+		},
+		{
+			name:     "saved named volume",
+			volume:   models.Volume{ID: 5, ProjectID: 2, Type: models.VolumeTypeNamed, Name: "orchestro-p2-v5", ContainerPath: "/data"},
+			wantName: "orchestro-p2-v5",
+		},
+		{
+			name:    "another project's volume",
+			volume:  models.Volume{ID: 5, ProjectID: 2, Type: models.VolumeTypeNamed, Name: "orchestro-p3-v5", ContainerPath: "/data"},
+			wantErr: "not backed by orchestro-p2-v5",
+		},
+		{
+			name:    "path as a name",
+			volume:  models.Volume{ID: 5, ProjectID: 2, Type: models.VolumeTypeNamed, Name: "/var/run", ContainerPath: "/data"},
+			wantErr: "not backed by",
+		},
+		{
+			name:    "name with a slash",
+			volume:  models.Volume{ID: 5, ProjectID: 2, Type: models.VolumeTypeNamed, Name: "orchestro-p2-v5/..", ContainerPath: "/data"},
+			wantErr: "not backed by",
+		},
+		{
+			name:     "bind",
+			volume:   models.Volume{HostPath: filepath.Join(dir, "data") + "/", ContainerPath: "/data/"},
+			wantHost: filepath.Join(dir, "data"),
+		},
+		{
+			name:    "denied bind",
+			volume:  models.Volume{Type: models.VolumeTypeBind, HostPath: "/etc", ContainerPath: "/data"},
+			wantErr: "not allowed",
+		},
+		{
+			name:    "relative container path",
+			volume:  models.Volume{HostPath: filepath.Join(dir, "data"), ContainerPath: "data"},
+			wantErr: "must be absolute",
+		},
+		{
+			name:    "unknown type",
+			volume:  models.Volume{Type: "tmpfs", ContainerPath: "/data"},
+			wantErr: "bind or named",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.volume
+			err := checkVolume(&v)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v.Name != tt.wantName || v.HostPath != tt.wantHost || v.ContainerPath != "/data" {
+				t.Fatalf("got name %q, host path %q, container path %q", v.Name, v.HostPath, v.ContainerPath)
+			}
+		})
+	}
+}
+
+func mustAbs(t *testing.T, p string) string {
+	t.Helper()
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
+
+func checkResult(t *testing.T, path, got string, err error, want, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("Check(%q) error = %v, want one containing %q", path, err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Check(%q): %v", path, err)
+	}
+	if got != want {
+		t.Fatalf("Check(%q) = %q, want %q", path, got, want)
+	}
+}
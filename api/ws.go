@@ -23,11 +23,18 @@ type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+	// canSee filters broadcasts down to the projects the user may access.
+	canSee func(projectID uint) bool
+}
+
+type projectMessage struct {
+	projectID uint
+	data      []byte
 }
 
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan projectMessage
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.Mutex
@@ -35,7 +42,7 @@ type Hub struct {
 
 func newHub() *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan projectMessage),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
@@ -62,8 +69,11 @@ func (h *Hub) run() {
 		case message := <-h.broadcast:
 			h.mu.Lock()
 			for client := range h.clients {
+				if !client.canSee(message.projectID) {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- message.data:
 				default:
 					close(client.send)
 					delete(h.clients, client)
@@ -135,7 +145,7 @@ func (h *Hub) BroadcastStatus(projectID uint, status string, port int) {
 		"status":     status,
 		"port":       port,
 	})
-	h.broadcast <- msg
+	h.broadcast <- projectMessage{projectID: projectID, data: msg}
 }
 
 func (h *Hub) BroadcastLogs(projectID uint, logLine string) {
@@ -144,16 +154,16 @@ func (h *Hub) BroadcastLogs(projectID uint, logLine string) {
 		"project_id": projectID,
 		"log":        logLine,
 	})
-	h.broadcast <- msg
+	h.broadcast <- projectMessage{projectID: projectID, data: msg}
 }
 
-func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+func serveWs(hub *Hub, canSee func(projectID uint) bool, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		fmt.Printf("Error upgrading to websocket: %v\n", err)
 		return
 	}
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
+	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256), canSee: canSee}
 	client.hub.register <- client
 
 	go client.writePump()
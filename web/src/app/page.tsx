@@ -1,9 +1,9 @@
 "use client";
 
 import { useEffect, useState, useCallback } from "react";
-import { Plus, ExternalLink, Package, X, Settings, RefreshCw, ChevronDown, Box, Layers } from "lucide-react";
+import { Plus, ExternalLink, Package, X, Settings, RefreshCw, ChevronDown, Box, Layers, LogOut } from "lucide-react";
 import Link from "next/link";
-import { apiFetch, getWsUrl } from "@/lib/api";
+import { apiFetch, getWsUrl, logout } from "@/lib/api";
 
 interface ModalProps {
   isOpen: boolean;
@@ -277,13 +277,18 @@ export default function Home() {
           <h1 className="text-6xl font-serif tracking-tight">Orchestro</h1>
           <p className="text-zinc-500 mt-2 text-lg">Deployments, simplified.</p>
         </div>
-        <button 
-          onClick={() => setIsModalOpen(true)}
-          className="bg-white text-black px-6 py-2 rounded-full font-medium hover:bg-zinc-200 transition-colors flex items-center gap-2"
-        >
-          <Plus size={18} />
-          New Project
-        </button>
+        <div className="flex items-center gap-3">
+          <button 
+            onClick={() => setIsModalOpen(true)}
+            className="bg-white text-black px-6 py-2 rounded-full font-medium hover:bg-zinc-200 transition-colors flex items-center gap-2"
+          >
+            <Plus size={18} />
+            New Project
+          </button>
+          <button onClick={logout} title="Sign out" className="p-2 text-zinc-500 hover:text-white transition-colors">
+            <LogOut size={18} />
+          </button>
+        </div>
       </header>
 
       <main className="max-w-5xl mx-auto">
@@ -397,7 +402,7 @@ export default function Home() {
                           className={`w-1.5 h-1.5 rounded-full ${
                             project.live_state === "running" || project.deployments?.[0]?.status === "ready"
                               ? "bg-green-500"
-                              : project.deployments?.[0]?.status === "failed"
+                              : project.deployments?.[0]?.status === "failed" || project.deployments?.[0]?.status === "timed_out"
                                 ? "bg-red-500"
                                 : project.deployments?.[0]?.status === "paused"
                                   ? "bg-yellow-500"
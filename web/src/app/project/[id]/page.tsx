@@ -21,7 +21,7 @@ import {
   Play
 } from "lucide-react";
 import Link from "next/link";
-import { apiFetch, getWsUrl, API_URL } from "@/lib/api";
+import { apiFetch, getWsUrl, API_URL, withToken } from "@/lib/api";
 
 interface ModalProps {
   isOpen: boolean;
@@ -73,25 +73,43 @@ interface Backup {
 
 interface Volume {
   id: number;
+  type: "bind" | "named";
   host_path: string;
+  name: string;
   container_path: string;
+  size?: number;
 }
 
 interface Project {
   id: number;
   name: string;
+  type: string;
   repo_url: string;
+  image: string;
+  registry_username: string;
+  registry_password: string;
   base_image: string;
   branch: string;
   root_directory: string;
   install_command: string;
   build_command: string;
   start_command: string;
+  release_command: string;
   output_directory: string;
   custom_port: number;
   internal_port: number;
+  replicas: number;
+  networks: string[] | null;
+  network_alias: string;
+  internal: boolean;
   docker_compose: string;
   custom_dockerfile: string;
+  dockerfile_path: string;
+  build_context: string;
+  build_target: string;
+  build_args: Record<string, string> | null;
+  build_excludes: string[] | null;
+  build_timeout_seconds: number;
   deployment_type: 'standard' | 'docker-compose';
   webhook_secret: string;
   git_provider: string;
@@ -119,7 +137,7 @@ export default function ProjectPage() {
   const [isEnvModalOpen, setIsEnvModalOpen] = useState(false);
   const [isVolumeModalOpen, setIsVolumeModalOpen] = useState(false);
   const [newEnv, setNewEnv] = useState({ key: "", value: "" });
-  const [newVolume, setNewVolume] = useState({ host_path: "", container_path: "" });
+  const [newVolume, setNewVolume] = useState({ type: "bind", host_path: "", container_path: "" });
   const [confirmModal, setConfirmModal] = useState<{
     isOpen: boolean;
     title: string;
@@ -321,7 +339,7 @@ export default function ProjectPage() {
 
   const handleAddVolume = async (e: React.FormEvent) => {
     e.preventDefault();
-    if (!newVolume.host_path || !newVolume.container_path) return;
+    if ((newVolume.type === "bind" && !newVolume.host_path) || !newVolume.container_path) return;
     const res = await apiFetch(`/api/v1/projects/${id}/volumes`, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
@@ -335,7 +353,7 @@ export default function ProjectPage() {
         volumes: [...(prev.volumes || []), created]
       } : null);
       setIsVolumeModalOpen(false);
-      setNewVolume({ host_path: "", container_path: "" });
+      setNewVolume({ type: "bind", host_path: "", container_path: "" });
     }
   };
 
@@ -356,6 +374,22 @@ export default function ProjectPage() {
     });
   };
 
+  const handleDeleteBackup = async (backupId: number) => {
+    setConfirmModal({
+      isOpen: true,
+      title: "Delete Backup",
+      message: "Are you sure you want to permanently delete this backup?",
+      onConfirm: async () => {
+        setProject(prev => prev ? {
+          ...prev,
+          backups: prev.backups?.filter(b => b.id !== backupId)
+        } : null);
+        setConfirmModal(null);
+        await apiFetch(`/api/v1/backups/${backupId}`, { method: "DELETE" });
+      },
+    });
+  };
+
   const handleCreateBackup = async () => {
     await apiFetch(`/api/v1/projects/${id}/backups`, { method: "POST" });
     fetchProject();
@@ -385,6 +419,19 @@ export default function ProjectPage() {
     });
   };
 
+  const handleClearBuildCache = async () => {
+    setConfirmModal({
+      isOpen: true,
+      title: "Clear Build Cache",
+      variant: "danger",
+      message: "The next build will start from scratch and reinstall every package. Continue?",
+      onConfirm: async () => {
+        await apiFetch(`/api/v1/projects/${id}/build-cache`, { method: "DELETE" });
+        setConfirmModal(null);
+      },
+    });
+  };
+
   if (!project) return (
     <div className="min-h-screen bg-black text-white font-sans p-8">
       <div className="max-w-6xl mx-auto space-y-12">
@@ -544,7 +591,7 @@ export default function ProjectPage() {
                                             ? "bg-green-500"
                                             : currentStatus === "paused"
                                               ? "bg-yellow-500"
-                                              : currentStatus === "failed"
+                                              : currentStatus === "failed" || currentStatus === "timed_out"
                                                 ? "bg-red-500"
                                                 : currentStatus === "building"
                                                   ? "bg-blue-500 animate-pulse"
@@ -614,7 +661,7 @@ export default function ProjectPage() {
                     {project.volumes?.length === 0 ? <div className="h-full flex items-center justify-center border border-dashed border-zinc-900 rounded-2xl italic text-zinc-600 text-xs text-center p-4">No persistent volumes</div> : project.volumes?.map((v: Volume) => (
                       <div key={v.id} className="bg-black/40 border border-zinc-900 p-3 rounded-xl group transition-colors hover:border-zinc-800 relative">
                         <div className="flex flex-col gap-2 overflow-hidden">
-                          <div className="flex items-center gap-2"><div className="w-1 h-1 rounded-full bg-zinc-700" /><code className="text-[10px] text-zinc-500 truncate">{v.host_path}</code></div>
+                          <div className="flex items-center gap-2"><div className="w-1 h-1 rounded-full bg-zinc-700" /><code className="text-[10px] text-zinc-500 truncate">{v.type === "named" ? `${v.name}${v.size !== undefined ? ` (${(v.size / 1024 / 1024).toFixed(1)} MB)` : ""}` : v.host_path}</code></div>
                           <div className="flex items-center gap-2"><div className="w-1 h-1 rounded-full bg-blue-500" /><code className="text-[11px] text-zinc-300 truncate">{v.container_path}</code></div>
                         </div>
                         <button onClick={() => handleDeleteVolume(v.id)} className="absolute top-3 right-3 p-1.5 text-zinc-700 hover:text-red-500 transition-colors"><X size={14} /></button>
@@ -659,7 +706,7 @@ export default function ProjectPage() {
                     {project.deployments?.length === 0 ? <div className="py-12 text-center text-zinc-700 italic bg-black/20 border border-dashed border-zinc-900 rounded-2xl">No deployment history available</div> : project.deployments?.slice(0, 5).map((d: Deployment) => (
                       <div key={d.id} className="flex flex-col sm:flex-row sm:justify-between sm:items-center bg-black/40 border border-zinc-900 p-4 rounded-2xl group hover:border-zinc-800 transition-colors gap-4">
                         <div className="flex items-center gap-4">
-                          <div className={`w-2 h-2 rounded-full shrink-0 ${d.status === "ready" ? "bg-green-500" : d.status === "failed" ? "bg-red-500" : "bg-zinc-700"}`} />
+                          <div className={`w-2 h-2 rounded-full shrink-0 ${d.status === "ready" ? "bg-green-500" : d.status === "failed" || d.status === "timed_out" ? "bg-red-500" : "bg-zinc-700"}`} />
                           <div><p className="text-sm font-medium capitalize text-zinc-300">{d.status}</p><p className="text-[10px] text-zinc-600 font-mono">{new Date(d.created_at).toLocaleString()}</p></div>
                         </div>
                         <button onClick={() => { if (d.logs) setLogs(d.logs); setActiveTab("logs"); }} className="text-[10px] uppercase font-bold text-zinc-500 group-hover:text-white transition-colors flex items-center gap-2"><Terminal size={14} /> Inspect Logs</button>
@@ -680,14 +727,17 @@ export default function ProjectPage() {
           {activeTab === "backups" && (
             <div className="bg-zinc-950 border border-zinc-900 rounded-3xl p-6 sm:p-8 animate-modal-enter">
               <div className="flex flex-col sm:flex-row sm:justify-between sm:items-center gap-6 mb-8">
-                <div><h3 className="text-2xl font-serif">Project Backups</h3><p className="text-zinc-500 text-sm mt-1">Manual snapshots of your database and volumes.</p></div>
+                <div><h3 className="text-2xl font-serif">Project Backups</h3><p className="text-zinc-500 text-sm mt-1">Manual snapshots of this project's settings, env vars and volumes.</p></div>
                 <button onClick={handleCreateBackup} className="bg-white text-black px-6 py-2 rounded-full font-medium hover:bg-zinc-200 transition-colors w-full sm:w-auto">Create Backup</button>
               </div>
               <div className="space-y-4">
                 {project.backups?.length === 0 ? <div className="py-20 text-center text-zinc-600">No backups created yet.</div> : project.backups?.map((b: Backup) => (
                   <div key={b.id} className="flex flex-col sm:flex-row sm:justify-between sm:items-center bg-zinc-900/30 border border-zinc-900 p-4 rounded-2xl gap-4">
                     <div className="flex items-center gap-4"><Archive className="text-zinc-500" size={20} /><div className="min-w-0"><p className="text-sm font-medium truncate">{new Date(b.created_at).toLocaleString()}</p><p className="text-xs text-zinc-500">{(b.size / 1024 / 1024).toFixed(2)} MB</p></div></div>
-                    <a href={`${API_URL}/api/v1/backups/${b.id}/download`} download className="text-xs text-zinc-400 hover:text-white transition-colors bg-white/5 border border-white/10 px-3 py-1 rounded-lg text-center">Download</a>
+                    <div className="flex items-center gap-2">
+                      <a href={withToken(`${API_URL}/api/v1/backups/${b.id}/download`)} download className="text-xs text-zinc-400 hover:text-white transition-colors bg-white/5 border border-white/10 px-3 py-1 rounded-lg text-center flex-1">Download</a>
+                      <button onClick={() => handleDeleteBackup(b.id)} className="p-1.5 text-zinc-700 hover:text-red-500 transition-colors"><X size={14} /></button>
+                    </div>
                   </div>
                 ))}
               </div>
@@ -735,6 +785,14 @@ export default function ProjectPage() {
                 </div>
                 
                 <form onSubmit={handleUpdateProject} className="space-y-6">
+                  <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Source</label><select value={project.type || "git"} onChange={(e) => setProject({ ...project, type: e.target.value })} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors"><option value="git">Git repository</option><option value="image">Prebuilt image</option></select></div>
+                  {project.type === "image" && (
+                    <div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 gap-6">
+                      <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Image</label><input type="text" value={project.image || ""} onChange={(e) => setProject({ ...project, image: e.target.value })} placeholder="ghcr.io/org/app:latest" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
+                      <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Registry User</label><input type="text" value={project.registry_username || ""} onChange={(e) => setProject({ ...project, registry_username: e.target.value })} placeholder="Public image" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
+                      <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Registry Password</label><input type="password" value={project.registry_password || ""} onChange={(e) => setProject({ ...project, registry_password: e.target.value })} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
+                    </div>
+                  )}
                   <div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 gap-6">
                     <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Repository URL</label><input type="text" value={project.repo_url || ""} onChange={(e) => setProject({ ...project, repo_url: e.target.value })} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
                     <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Branch</label><input type="text" value={project.branch || ""} onChange={(e) => setProject({ ...project, branch: e.target.value })} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
@@ -743,6 +801,19 @@ export default function ProjectPage() {
                   <div className="space-y-1.5">
                     <div className="flex justify-between items-center ml-1"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold">Root Directory</label><span className="text-[9px] text-zinc-600">Optional • Subfolder for monorepos</span></div>
                     <input type="text" value={project.root_directory || ""} onChange={(e) => setProject({ ...project, root_directory: e.target.value })} placeholder="e.g. apps/web" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" />
+                  </div>
+                  <div className="space-y-1.5">
+                    <div className="flex justify-between items-center ml-1"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold">Build Excludes</label><span className="text-[9px] text-zinc-600">Optional • Added to .dockerignore</span></div>
+                    <input type="text" defaultValue={(project.build_excludes || []).join(", ")} onBlur={(e) => setProject({ ...project, build_excludes: e.target.value.split(",").map((p) => p.trim()).filter(Boolean) })} placeholder="e.g. .env*, coverage" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" />
+                  </div>
+                  <div className="grid grid-cols-1 md:grid-cols-3 gap-6">
+                    <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Dockerfile Path</label><input type="text" value={project.dockerfile_path || ""} onChange={(e) => setProject({ ...project, dockerfile_path: e.target.value })} placeholder="Generated" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" /></div>
+                    <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Build Context</label><input type="text" value={project.build_context || ""} onChange={(e) => setProject({ ...project, build_context: e.target.value })} placeholder={project.root_directory || "Repository root"} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" /></div>
+                    <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Build Target</label><input type="text" value={project.build_target || ""} onChange={(e) => setProject({ ...project, build_target: e.target.value })} placeholder="Last stage" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" /></div>
+                  </div>
+                  <div className="space-y-1.5">
+                    <div className="flex justify-between items-center ml-1"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold">Build Args</label><span className="text-[9px] text-zinc-600">Optional • On top of env vars</span></div>
+                    <input type="text" defaultValue={Object.entries(project.build_args || {}).map(([k, v]) => `${k}=${v}`).join(", ")} onBlur={(e) => setProject({ ...project, build_args: Object.fromEntries(e.target.value.split(",").map((a) => a.trim()).filter(Boolean).map((a) => { const i = a.indexOf("="); return i < 0 ? [a, ""] : [a.slice(0, i).trim(), a.slice(i + 1)]; })) })} placeholder="e.g. NODE_VERSION=22" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" />
                   </div>
                                     <div className="pt-4 border-t border-zinc-900 mt-8">
                                       <div className="flex items-center gap-3 mb-8">
@@ -763,10 +834,18 @@ export default function ProjectPage() {
                       <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Start Cmd</label><input type="text" value={project.start_command || ""} onChange={(e) => setProject({ ...project, start_command: e.target.value })} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" /></div>
                       <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Output Dir</label><input type="text" value={project.output_directory || ""} onChange={(e) => setProject({ ...project, output_directory: e.target.value })} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" /></div>
                     </div>
+                    <div className="space-y-1.5 mb-6"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Release Cmd</label><input type="text" value={project.release_command || ""} onChange={(e) => setProject({ ...project, release_command: e.target.value })} placeholder="e.g. bun run migrate" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" /><p className="text-[9px] text-zinc-600 ml-1">Runs in a one-off container from the new build before it goes live. A non-zero exit fails the deployment.</p></div>
+                    <div className="space-y-1.5 mb-6"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Build Timeout (min)</label><input type="number" value={project.build_timeout_seconds ? project.build_timeout_seconds / 60 : ""} onChange={(e) => setProject({ ...project, build_timeout_seconds: (parseInt(e.target.value) || 0) * 60 })} placeholder="Server default" min={1} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
                     <div className="grid grid-cols-1 sm:grid-cols-2 gap-6">
                       <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Public Port</label><input type="number" value={project.custom_port || ""} onChange={(e) => setProject({ ...project, custom_port: parseInt(e.target.value) || 0 })} placeholder="Auto" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
                       <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Internal Port</label><input type="number" value={project.internal_port || ""} onChange={(e) => setProject({ ...project, internal_port: parseInt(e.target.value) || 0 })} placeholder="80" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
+                      <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Replicas</label><input type="number" value={project.replicas || ""} onChange={(e) => setProject({ ...project, replicas: parseInt(e.target.value) || 1 })} placeholder="1" min={1} max={20} className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-sm focus:outline-none focus:border-white transition-colors" /></div>
+                    </div>
+                    <div className="grid grid-cols-1 sm:grid-cols-2 gap-6 mt-6">
+                      <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Networks</label><input type="text" defaultValue={(project.networks || []).join(", ")} onBlur={(e) => setProject({ ...project, networks: e.target.value.split(",").map((n) => n.trim()).filter(Boolean) })} placeholder="e.g. backend" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" /></div>
+                      <div className="space-y-1.5"><label className="text-[10px] uppercase tracking-wider text-zinc-500 font-bold ml-1">Network Alias</label><input type="text" value={project.network_alias || ""} onChange={(e) => setProject({ ...project, network_alias: e.target.value })} placeholder="From project name" className="w-full bg-black border border-zinc-900 rounded-xl px-4 py-2.5 text-xs font-mono focus:outline-none focus:border-white transition-colors" /></div>
                     </div>
+                    <label className="flex items-center gap-2 mt-4 ml-1 text-xs text-zinc-400"><input type="checkbox" checked={!!project.internal} onChange={(e) => setProject({ ...project, internal: e.target.checked })} /> Internal only: no public port, reachable from its networks by alias</label>
                   </div>
                   <div className="pt-6"><button disabled={isSaving} className="bg-white text-black px-8 py-3 rounded-2xl text-sm font-bold hover:bg-zinc-200 transition-all active:scale-95 disabled:opacity-50 w-full sm:w-auto">{isSaving ? "Updating Project..." : "Save All Changes"}</button></div>
                 </form>
@@ -830,6 +909,11 @@ export default function ProjectPage() {
                 </div>
               </div>
 
+              <div className="bg-zinc-950 border border-zinc-900 rounded-3xl p-6 sm:p-8 max-w-3xl flex flex-col sm:flex-row sm:justify-between sm:items-center mb-6 gap-6">
+                <div><h3 className="text-xl font-serif">Build Cache</h3><p className="text-xs text-zinc-500 mt-1">Cached layers and package downloads that speed up rebuilds.</p></div>
+                <button onClick={handleClearBuildCache} className="text-xs bg-zinc-900 border border-zinc-800 px-6 py-2.5 rounded-xl font-medium hover:bg-zinc-800 transition-all active:scale-95 w-full sm:w-auto">Clear Build Cache</button>
+              </div>
+
               <div className="bg-red-500/5 border border-red-500/10 rounded-3xl p-6 sm:p-8 max-w-3xl flex flex-col sm:flex-row sm:justify-between sm:items-center mb-6 gap-6">
                 <div><h3 className="text-xl font-serif text-red-500">Reset Local State</h3><p className="text-xs text-zinc-500 mt-1">Delete all project files, logs, and reset the environment.</p></div>
                 <button onClick={handleClearData} className="bg-red-500/10 text-red-500 border border-red-500/20 px-6 py-2.5 rounded-xl hover:bg-red-500/20 transition-all active:scale-95 text-xs font-bold w-full sm:w-auto">Clear Project Data</button>
@@ -855,10 +939,21 @@ export default function ProjectPage() {
       <CustomModal isOpen={isVolumeModalOpen} onClose={() => setIsVolumeModalOpen(false)} title="Add Docker Volume">
         <form onSubmit={handleAddVolume} className="space-y-4">
           <div>
-            <label className="block text-xs text-zinc-500 mb-1">Host Path (Machine)</label>
-            <input required type="text" value={newVolume.host_path} onChange={(e) => setNewVolume({ ...newVolume, host_path: e.target.value })} className="w-full bg-black border border-zinc-800 rounded-xl px-4 py-2 text-sm focus:outline-none focus:border-white transition-colors" placeholder="/home/user/data" />
-            <p className="text-[9px] text-zinc-600 mt-1">Must be an <b>absolute path</b> (starts with /)</p>
+            <label className="block text-xs text-zinc-500 mb-1">Type</label>
+            <select value={newVolume.type} onChange={(e) => setNewVolume({ ...newVolume, type: e.target.value })} className="w-full bg-black border border-zinc-800 rounded-xl px-4 py-2 text-sm focus:outline-none focus:border-white transition-colors">
+              <option value="bind">Host directory</option>
+              <option value="named">Managed Docker volume</option>
+            </select>
           </div>
+          {newVolume.type === "bind" ? (
+            <div>
+              <label className="block text-xs text-zinc-500 mb-1">Host Path (Machine)</label>
+              <input required type="text" value={newVolume.host_path} onChange={(e) => setNewVolume({ ...newVolume, host_path: e.target.value })} className="w-full bg-black border border-zinc-800 rounded-xl px-4 py-2 text-sm focus:outline-none focus:border-white transition-colors" placeholder="/home/user/data" />
+              <p className="text-[9px] text-zinc-600 mt-1">Must be an <b>absolute path</b> (starts with /)</p>
+            </div>
+          ) : (
+            <p className="text-[10px] text-zinc-600 leading-relaxed">Orchestro creates and names the volume. It is removed when the project is deleted or its data is cleared.</p>
+          )}
           <div><label className="block text-xs text-zinc-500 mb-1">Container Path</label><input required type="text" value={newVolume.container_path} onChange={(e) => setNewVolume({ ...newVolume, container_path: e.target.value })} className="w-full bg-black border border-zinc-800 rounded-xl px-4 py-2 text-sm focus:outline-none focus:border-white transition-colors" placeholder="/app/data" /></div>
           <p className="text-[10px] text-zinc-600 leading-relaxed italic">Note: Changes will take effect after the next redeployment.</p>
           <button className="w-full bg-white text-black font-medium py-3 rounded-xl hover:bg-zinc-200 transition-colors mt-4">Add Volume</button>
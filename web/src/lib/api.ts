@@ -1,11 +1,16 @@
 const API_URL = process.env.NEXT_PUBLIC_API_URL || "http://localhost:8080";
-const API_USER = process.env.NEXT_PUBLIC_API_USER;
-const API_PASS = process.env.NEXT_PUBLIC_API_PASS;
+const TOKEN_KEY = "orchestro_token";
+
+export const getToken = (): string | null => {
+  if (typeof window === "undefined") return null;
+  return localStorage.getItem(TOKEN_KEY);
+};
 
 const getAuthHeader = (): Record<string, string> => {
-  if (API_USER && API_PASS) {
+  const token = getToken();
+  if (token) {
     return {
-      Authorization: `Basic ${btoa(`${API_USER}:${API_PASS}`)}`,
+      Authorization: `Bearer ${token}`,
     };
   }
   return {};
@@ -13,7 +18,7 @@ const getAuthHeader = (): Record<string, string> => {
 
 export const apiFetch = async (endpoint: string, options: RequestInit = {}) => {
   const url = endpoint.startsWith("http") ? endpoint : `${API_URL}${endpoint}`;
-  
+
   const headers = new Headers(options.headers);
   const auth = getAuthHeader();
   for (const [key, value] of Object.entries(auth)) {
@@ -22,27 +27,53 @@ export const apiFetch = async (endpoint: string, options: RequestInit = {}) => {
     }
   }
 
-  return fetch(url, {
+  const res = await fetch(url, {
     ...options,
     headers,
   });
+
+  if (res.status === 401 && typeof window !== "undefined" && window.location.pathname !== "/login") {
+    localStorage.removeItem(TOKEN_KEY);
+    window.location.href = "/login";
+  }
+
+  return res;
 };
 
-export const getWsUrl = (path: string = "/ws") => {
-  let wsUrl = API_URL.replace(/^http/, "ws").replace(/\/$/, "");
-  
-  if (API_USER && API_PASS) {
-    try {
-      const url = new URL(wsUrl);
-      url.username = API_USER;
-      url.password = API_PASS;
-      wsUrl = url.toString().replace(/\/$/, "");
-    } catch (e) {
-      console.error("Invalid API_URL for WebSocket construction:", e);
-    }
+export const login = async (username: string, password: string) => {
+  const res = await fetch(`${API_URL}/api/v1/auth/login`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ username, password }),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(data.error || "Login failed");
   }
-  
-  return `${wsUrl}${path}`;
+  localStorage.setItem(TOKEN_KEY, data.token);
+  return data.user;
+};
+
+export const logout = async () => {
+  await apiFetch("/api/v1/auth/logout", { method: "POST" });
+  localStorage.removeItem(TOKEN_KEY);
+  window.location.href = "/login";
+};
+
+// Browsers cannot set headers on WebSocket requests, so the session token is
+// passed as a query parameter instead.
+export const getWsUrl = (path: string = "/ws") => {
+  const wsUrl = API_URL.replace(/^http/, "ws").replace(/\/$/, "");
+  const token = getToken();
+  const query = token ? `?token=${encodeURIComponent(token)}` : "";
+  return `${wsUrl}${path}${query}`;
+};
+
+// Plain links (backup downloads) cannot carry an Authorization header either.
+export const withToken = (url: string) => {
+  const token = getToken();
+  if (!token) return url;
+  return `${url}${url.includes("?") ? "&" : "?"}token=${encodeURIComponent(token)}`;
 };
 
 export { API_URL };